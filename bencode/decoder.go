@@ -2,134 +2,407 @@ package bencode
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"reflect"
 	"strconv"
+	"strings"
 )
 
+// Limits applied by NewDecoder, and therefore by Decode and Unmarshal.
+const (
+	DefaultMaxStringLength = 64 << 20
+	DefaultMaxDepth        = 256
+)
+
+// preallocLimit is the longest string allocated in full before reading it.
+// Longer ones grow as their data arrives, so a length the input cannot back
+// does not allocate more than the input holds.
+const preallocLimit = 1 << 20
+
+// Decoder reads bencoded values from an input stream. The exported fields can
+// be adjusted after NewDecoder and before the first call to Decode.
+type Decoder struct {
+	// MaxStringLength is the longest byte string accepted, 0 means no limit.
+	MaxStringLength int64
+	// MaxDepth is the deepest nesting of lists and dictionaries accepted,
+	// 0 means no limit.
+	MaxDepth int
+	// MaxSize is the most input the decoder will consume, 0 means no limit.
+	MaxSize int64
+	// Strict rejects anything that is not canonical bencode: integers and
+	// string lengths with leading zeros, "-0", dictionary keys that are
+	// unsorted or duplicated, and data following the decoded value.
+	Strict bool
+
+	r      *reader
+	depth  int
+	path   []string
+	tokens []container
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		MaxStringLength: DefaultMaxStringLength,
+		MaxDepth:        DefaultMaxDepth,
+		r:               &reader{Reader: bufio.NewReader(r)},
+	}
+}
+
 func Decode(r io.Reader) (interface{}, error) {
-	reader := bufio.NewReader(r)
-	return decodeRecursive(reader)
+	var v interface{}
+	err := NewDecoder(r).Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
 }
 
-func decodeRecursive(r *bufio.Reader) (interface{}, error) {
-	firstByte, err := r.Peek(1)
+// Decode reads the next bencoded value and stores it in the value pointed to
+// by v, following the same rules as Unmarshal.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("decode requires a non-nil pointer, got %T", v)
+	}
+
+	err := d.unmarshalValue(rv.Elem())
 	if err != nil {
-		return nil, fmt.Errorf("error while peeking into the buffer")
+		return err
 	}
+	d.valueDone()
 
-	switch {
-	case firstByte[0] == 'i':
-		return decodeInt(r)
-	case firstByte[0] >= '0' && firstByte[0] <= '9':
-		return decodeString(r)
-	case firstByte[0] == 'l':
-		return decodeList(r)
-	case firstByte[0] == 'd':
-		return decodeDict(r)
-	default:
-		return nil, fmt.Errorf("invalid bencode type: got %c", firstByte[0])
+	return d.checkTrailing()
+}
+
+// InputOffset returns the number of input bytes consumed so far.
+func (d *Decoder) InputOffset() int64 {
+	return d.r.offset
+}
+
+// reader is the buffered input shared by the decoding functions. It tracks
+// the input offset for error reporting, and while recording is enabled every
+// consumed byte is also appended to recorded, which is how RawMessage keeps
+// the exact encoding of a value.
+type reader struct {
+	*bufio.Reader
+	offset    int64
+	recording bool
+	recorded  []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.offset += int64(n)
+	if r.recording {
+		r.recorded = append(r.recorded, p[:n]...)
+	}
+	return n, err
+}
+
+func (r *reader) ReadByte() (byte, error) {
+	b, err := r.Reader.ReadByte()
+	if err == nil {
+		r.offset++
+		if r.recording {
+			r.recorded = append(r.recorded, b)
+		}
 	}
+	return b, err
 }
 
-func decodeInt(r *bufio.Reader) (int64, error) {
+// captureRaw decodes the next value and returns its bytes exactly as they
+// appeared in the input.
+func (d *Decoder) captureRaw() ([]byte, error) {
+	d.r.recording = true
+	d.r.recorded = nil
+	defer func() { d.r.recording = false }()
 
-	_, err := r.ReadByte()
+	_, err := d.decodeRecursive()
 	if err != nil {
-		return 0, fmt.Errorf("error while decoding int")
+		return nil, err
 	}
 
-	byteStrings, err := r.ReadString('e')
+	return d.r.recorded, nil
+}
 
-	result, err := strconv.ParseInt(byteStrings[:len(byteStrings)-1], 10, 64)
+// peek returns the first byte of the next value without consuming it.
+func (d *Decoder) peek() (byte, error) {
+	if d.MaxSize > 0 && d.r.offset >= d.MaxSize {
+		return 0, d.errorf("input exceeds maximum size of %d bytes", d.MaxSize)
+	}
 
+	firstByte, err := d.r.Peek(1)
+	if err == io.EOF {
+		return 0, d.errorf("unexpected end of input")
+	}
 	if err != nil {
-		return 0, fmt.Errorf("error while decoding int")
+		return 0, d.errorf("error while peeking into the buffer: %v", err)
 	}
+	return firstByte[0], nil
+}
 
-	return result, nil
+func (d *Decoder) decodeRecursive() (interface{}, error) {
+	firstByte, err := d.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case firstByte == 'i':
+		return d.decodeInt()
+	case firstByte >= '0' && firstByte <= '9':
+		return d.decodeString()
+	case firstByte == 'l':
+		return d.decodeList()
+	case firstByte == 'd':
+		return d.decodeDict()
+	default:
+		return nil, d.errorf("invalid bencode type: got %q", firstByte)
+	}
 }
 
-func decodeString(r *bufio.Reader) (string, error) {
-	lengthString, err := r.ReadString(':')
+func (d *Decoder) decodeInt() (int64, error) {
+	d.r.ReadByte()
+
+	digits, err := d.readNumber('e')
 	if err != nil {
-		return "", fmt.Errorf("error while reading string: ")
+		return 0, err
 	}
 
-	length, err := strconv.ParseInt(lengthString[:len(lengthString)-1], 10, 64)
+	return d.parseNumber(digits, "integer")
+}
 
+// decodeString reads a byte string. Go strings hold arbitrary bytes, so this is
+// binary safe; it is the representation used by Decode and for dictionary keys.
+func (d *Decoder) decodeString() (string, error) {
+	bytes, err := d.decodeBytes()
 	if err != nil {
-		return "", fmt.Errorf("error while parsing integer")
+		return "", err
 	}
-	bytes := make([]byte, length)
+	return string(bytes), nil
+}
 
-	_, err = io.ReadFull(r, bytes)
+// decodeBytes reads a byte string without converting it, for targets such as
+// piece hashes, peer ids and compact peer lists.
+func (d *Decoder) decodeBytes() ([]byte, error) {
+	lengthString, err := d.readNumber(':')
+	if err != nil {
+		return nil, err
+	}
 
+	length, err := d.parseNumber(lengthString, "string length")
 	if err != nil {
-		return "", fmt.Errorf("error while reading into the bytes")
+		return nil, err
 	}
 
-	return string(bytes), nil
+	if length < 0 {
+		return nil, d.errorf("negative string length %d", length)
+	}
+	if d.MaxStringLength > 0 && length > d.MaxStringLength {
+		return nil, d.errorf("string length %d exceeds maximum of %d", length, d.MaxStringLength)
+	}
+	if d.MaxSize > 0 && d.r.offset+length > d.MaxSize {
+		return nil, d.errorf("input exceeds maximum size of %d bytes", d.MaxSize)
+	}
+
+	if length > preallocLimit {
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, d.r, length)
+		if err != nil {
+			return nil, d.errorf("error while reading into the bytes: %v", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	buf := make([]byte, length)
+
+	_, err = io.ReadFull(d.r, buf)
+
+	if err != nil {
+		return nil, d.errorf("error while reading into the bytes: %v", err)
+	}
+
+	return buf, nil
 }
 
-func decodeList(r *bufio.Reader) ([]interface{}, error) {
-	var res []interface{}
+// readNumber reads the text of an integer or string length up to and
+// excluding delim. The text is bounded so a missing delimiter cannot make the
+// decoder buffer the rest of the input.
+func (d *Decoder) readNumber(delim byte) (string, error) {
+	const maxDigits = 20 // sign plus the 19 digits of the largest int64
 
-	r.ReadByte()
+	var sb strings.Builder
 	for {
-		peekedByte, err := r.Peek(1)
-
+		b, err := d.r.ReadByte()
 		if err != nil {
-			return nil, fmt.Errorf("error while peeking")
+			return "", d.errorf("unexpected end of input in number")
 		}
-		if peekedByte[0] == 'e' {
-			break
+		if b == delim {
+			return sb.String(), nil
+		}
+		if sb.Len() == maxDigits {
+			return "", d.errorf("number too long")
+		}
+		sb.WriteByte(b)
+	}
+}
+
+func (d *Decoder) parseNumber(s string, what string) (int64, error) {
+	if s == "" || s == "-" {
+		return 0, d.errorf("empty %s", what)
+	}
+	if s[0] == '+' {
+		return 0, d.errorf("invalid %s %q", what, s)
+	}
+
+	if d.Strict {
+		digits := strings.TrimPrefix(s, "-")
+		if digits[0] == '0' && (len(digits) > 1 || len(s) > 1) {
+			return 0, d.errorf("non-canonical %s %q", what, s)
 		}
+	}
+
+	result, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return 0, d.errorf("invalid %s %q", what, s)
+	}
+
+	return result, nil
+}
+
+func (d *Decoder) decodeList() ([]interface{}, error) {
+	var res []interface{}
 
-		currentEle, err := decodeRecursive(r)
+	err := d.decodeListEntries(func(int) error {
+		currentEle, err := d.decodeRecursive()
 
 		if err != nil {
-			return nil, fmt.Errorf("error while getting recursive result")
+			return err
 		}
 
 		res = append(res, currentEle)
-	}
+		return nil
+	})
 
-	r.ReadByte()
-	return res, nil
+	return res, err
 }
 
-func decodeDict(r *bufio.Reader) (map[string]interface{}, error) {
+func (d *Decoder) decodeDict() (map[string]interface{}, error) {
 	res := make(map[string]interface{}, 0)
 
-	r.ReadByte()
-
-	for {
-		peekedByte, err := r.Peek(1)
+	err := d.decodeDictEntries(func(key string) error {
+		value, err := d.decodeRecursive()
 
 		if err != nil {
-			return nil, fmt.Errorf("error while peeking the bytes")
+			return err
 		}
 
-		if peekedByte[0] == 'e' {
+		res[key] = value
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// decodeListEntries consumes a list, calling fn once per element with the
+// reader positioned at the start of that element.
+func (d *Decoder) decodeListEntries(fn func(index int) error) error {
+	err := d.enter()
+	if err != nil {
+		return err
+	}
+
+	d.r.ReadByte()
+	for i := 0; ; i++ {
+		peekedByte, err := d.peek()
+
+		if err != nil {
+			return err
+		}
+		if peekedByte == 'e' {
 			break
 		}
 
-		key, err := decodeString(r)
+		d.path = append(d.path, fmt.Sprintf("[%d]", i))
+		err = fn(i)
+		d.path = d.path[:len(d.path)-1]
 
 		if err != nil {
-			return nil, fmt.Errorf("error while decoding key for dictionary")
+			return err
 		}
+	}
 
-		value, err := decodeRecursive(r)
+	d.r.ReadByte()
+	d.depth--
+	return nil
+}
+
+// decodeDictEntries consumes a dictionary, calling fn once per key with the
+// reader positioned at the start of the value.
+func (d *Decoder) decodeDictEntries(fn func(key string) error) error {
+	err := d.enter()
+	if err != nil {
+		return err
+	}
+
+	d.r.ReadByte()
+
+	var prevKey string
+	for i := 0; ; i++ {
+		peekedByte, err := d.peek()
 
 		if err != nil {
-			return nil, fmt.Errorf("error while parsing value")
+			return err
 		}
 
-		res[key] = value
+		if peekedByte == 'e' {
+			break
+		}
+
+		if peekedByte < '0' || peekedByte > '9' {
+			return d.errorf("dictionary key must be a string, got %q", peekedByte)
+		}
+
+		key, err := d.decodeString()
+
+		if err != nil {
+			return err
+		}
+
+		if d.Strict && i > 0 {
+			if key == prevKey {
+				return d.errorf("duplicate dictionary key %q", key)
+			}
+			if key < prevKey {
+				return d.errorf("dictionary key %q is not sorted after %q", key, prevKey)
+			}
+		}
+		prevKey = key
+
+		d.path = append(d.path, key)
+		err = fn(key)
+		d.path = d.path[:len(d.path)-1]
+
+		if err != nil {
+			return err
+		}
 	}
 
-	r.ReadByte()
-	return res, nil
+	d.r.ReadByte()
+	d.depth--
+	return nil
+}
+
+func (d *Decoder) enter() error {
+	d.depth++
+	if d.MaxDepth > 0 && d.depth > d.MaxDepth {
+		return d.errorf("nesting exceeds maximum depth of %d", d.MaxDepth)
+	}
+	return nil
 }
@@ -0,0 +1,210 @@
+package bencode
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// strictTests are inputs that only strict decoding rejects.
+var strictTests = []struct {
+	name  string
+	input string
+}{
+	{"unsorted keys", "d1:bi1e1:ai2ee"},
+	{"unsorted nested keys", "d1:ad1:yi1e1:xi2eee"},
+	{"duplicate keys", "d1:ai1e1:ai2ee"},
+	{"leading zero", "i03e"},
+	{"negative zero", "i-0e"},
+	{"negative leading zero", "i-03e"},
+	{"string length leading zero", "03:abc"},
+	{"trailing bytes", "i1ex"},
+	{"trailing value", "d1:ai1eei2e"},
+	{"trailing string", "3:abc3:def"},
+}
+
+func decodeWith(input string, strict bool) error {
+	decoder := NewDecoder(bytes.NewReader([]byte(input)))
+	decoder.Strict = strict
+
+	var v interface{}
+	return decoder.Decode(&v)
+}
+
+// tokensWith reads every token of input, as bencode dump does.
+func tokensWith(input string, strict bool) error {
+	decoder := NewDecoder(bytes.NewReader([]byte(input)))
+	decoder.Strict = strict
+
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	for _, test := range strictTests {
+		if err := decodeWith(test.input, true); err == nil {
+			t.Errorf("%s: strict Decode(%q) succeeded", test.name, test.input)
+		}
+		if err := tokensWith(test.input, true); err == nil {
+			t.Errorf("%s: strict Token(%q) succeeded", test.name, test.input)
+		}
+		if err := decodeWith(test.input, false); err != nil {
+			t.Errorf("%s: Decode(%q) failed: %v", test.name, test.input, err)
+		}
+	}
+}
+
+func TestNonStrict(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+	}{
+		{"unsorted keys", "d1:bi1e1:ai2ee", map[string]interface{}{"a": int64(2), "b": int64(1)}},
+		{"duplicate keys", "d1:ai1e1:ai2ee", map[string]interface{}{"a": int64(2)}},
+		{"leading zero", "i03e", int64(3)},
+		{"negative zero", "i-0e", int64(0)},
+		{"string length leading zero", "03:abc", "abc"},
+		{"trailing bytes", "i1ex", int64(1)},
+	}
+
+	for _, test := range tests {
+		var got interface{}
+		err := NewDecoder(bytes.NewReader([]byte(test.input))).Decode(&got)
+		if err != nil {
+			t.Errorf("%s: Decode(%q) failed: %v", test.name, test.input, err)
+			continue
+		}
+
+		gotEncoded, err := Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantEncoded, err := Marshal(test.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(gotEncoded, wantEncoded) {
+			t.Errorf("%s: Decode(%q) = %#v, want %#v", test.name, test.input, got, test.want)
+		}
+	}
+}
+
+func TestStrictCanonical(t *testing.T) {
+	valid := []string{
+		"i0e",
+		"i-3e",
+		"i42e",
+		"0:",
+		"3:abc",
+		"le",
+		"de",
+		"d1:ai1e1:bi2ee",
+		"d1:ad1:xi1e1:yi2ee1:bl1:x1:yee",
+	}
+
+	for _, input := range valid {
+		if err := decodeWith(input, true); err != nil {
+			t.Errorf("strict Decode(%q) failed: %v", input, err)
+		}
+		if err := tokensWith(input, true); err != nil {
+			t.Errorf("strict Token(%q) failed: %v", input, err)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	invalid := []string{
+		"",
+		"i1",
+		"ie",
+		"i+1e",
+		"i1.5e",
+		"-1:a",
+		"5:abc",
+		"l",
+		"d1:ae",
+		"di1ei2ee",
+		"x",
+	}
+
+	for _, input := range invalid {
+		for _, strict := range []bool{false, true} {
+			if err := decodeWith(input, strict); err == nil {
+				t.Errorf("Decode(%q) with strict=%v succeeded", input, strict)
+			}
+		}
+	}
+}
+
+func TestStringLongerThanInput(t *testing.T) {
+	// without a limit the claimed length must not be allocated up front
+	decoder := NewDecoder(bytes.NewReader([]byte("1099511627776:abc")))
+	decoder.MaxStringLength = 0
+
+	var v interface{}
+	if err := decoder.Decode(&v); err == nil {
+		t.Error("decoding a string longer than the input succeeded")
+	}
+
+	long := bytes.Repeat([]byte("x"), 3*preallocLimit)
+	input := append([]byte("3145728:"), long...)
+	var got []byte
+	if err := Unmarshal(input, &got); err != nil || !bytes.Equal(got, long) {
+		t.Errorf("decoding a %d byte string failed: %v", len(long), err)
+	}
+}
+
+func TestSyntaxErrorPath(t *testing.T) {
+	type file struct {
+		Length int64    `bencode:"length"`
+		Path   []string `bencode:"path"`
+	}
+	type torrent struct {
+		Info struct {
+			Files []file `bencode:"files"`
+			Name  string `bencode:"name"`
+		} `bencode:"info"`
+	}
+
+	okFile := "d6:lengthi1e4:pathl1:aee"
+	prefix := "d4:infod5:filesl" + okFile + okFile + okFile + "d6:lengthi"
+	input := prefix + "1x2e4:pathl1:aeeee4:name1:nee"
+	// the integer is reported once its text is read
+	offset := int64(len(prefix + "1x2e"))
+
+	tests := []struct {
+		name   string
+		v      interface{}
+		path   string
+		offset int64
+	}{
+		{"struct", new(torrent), "info.files[3].length", offset},
+		{"generic", new(interface{}), "info.files[3].length", offset},
+	}
+
+	for _, test := range tests {
+		err := Unmarshal([]byte(input), test.v)
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("%s: got error %v, want a SyntaxError", test.name, err)
+		}
+		if syntaxErr.Path != test.path || syntaxErr.Offset != test.offset {
+			t.Errorf("%s: got path %q at offset %d, want %q at %d", test.name, syntaxErr.Path, syntaxErr.Offset, test.path, test.offset)
+		}
+	}
+
+	// a type mismatch reports the path of the value that did not fit
+	err := Unmarshal([]byte("d4:infod5:filesl"+okFile+"d6:length1:xeeee"), new(torrent))
+	syntaxErr, ok := err.(*SyntaxError)
+	if !ok || syntaxErr.Path != "info.files[1].length" {
+		t.Errorf("got error %v, want one at info.files[1].length", err)
+	}
+}
@@ -3,22 +3,42 @@ package bencode
 import (
 	"fmt"
 	"io"
+	"reflect"
 	"slices"
+	"strconv"
 )
 
+// Encode writes the bencoding of v to w. The generic types produced by Decode
+// are encoded directly; anything else (other integer kinds, bools, byte
+// arrays, structs, typed slices and maps) goes through the same reflection
+// rules as Marshal. Values with no bencode representation are an error.
 func Encode(w io.Writer, v interface{}) error {
 
 	switch val := v.(type) {
 	case string:
 		return encodeString(w, val)
+	case []byte:
+		return encodeBytes(w, val)
 	case int64:
 		return encodeInt(w, val)
+	case int:
+		return encodeInt(w, int64(val))
 	case []interface{}:
 		return encodeList(w, val)
 	case map[string]interface{}:
 		return encodeDict(w, val)
+	case RawMessage:
+		_, err := w.Write(val)
+		return err
+	case nil:
+		return fmt.Errorf("cannot encode nil value")
 	}
-	return nil
+
+	generic, err := marshalValue(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	return Encode(w, generic)
 }
 
 func encodeString(w io.Writer, val string) error {
@@ -31,6 +51,19 @@ func encodeString(w io.Writer, val string) error {
 	return nil
 }
 
+func encodeBytes(w io.Writer, val []byte) error {
+	buf := strconv.AppendInt(make([]byte, 0, len(val)+21), int64(len(val)), 10)
+	buf = append(buf, ':')
+	buf = append(buf, val...)
+
+	_, err := w.Write(buf)
+
+	if err != nil {
+		return fmt.Errorf("error while encoding the bytes: %v", err)
+	}
+	return nil
+}
+
 func encodeInt(w io.Writer, v int64) error {
 	_, err := w.Write([]byte(fmt.Sprintf("i%de", v)))
 
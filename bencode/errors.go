@@ -0,0 +1,40 @@
+package bencode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError describes malformed or disallowed input, with the input offset
+// and the path of the value being decoded, e.g. "info.files[3].length".
+type SyntaxError struct {
+	Offset int64
+	Path   string
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+	}
+	return fmt.Sprintf("bencode: %s at offset %d (%s)", e.Msg, e.Offset, e.Path)
+}
+
+func (d *Decoder) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Offset: d.r.offset,
+		Path:   d.pathString(),
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (d *Decoder) pathString() string {
+	var sb strings.Builder
+	for _, elem := range d.path {
+		if sb.Len() > 0 && !strings.HasPrefix(elem, "[") {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(elem)
+	}
+	return sb.String()
+}
@@ -0,0 +1,127 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// Marshal returns the bencoding of v. Structs are encoded as dictionaries
+// keyed by their `bencode` tags, slices and arrays as lists, maps with
+// string keys as dictionaries and all integer kinds as integers.
+func Marshal(v interface{}) ([]byte, error) {
+	val, err := marshalValue(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = Encode(&buf, val)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// marshalValue converts v into the generic representation understood by
+// Encode: string, []byte, int64, []interface{}, map[string]interface{} and
+// RawMessage.
+func marshalValue(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, fmt.Errorf("cannot marshal nil value")
+	}
+
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return nil, fmt.Errorf("cannot marshal empty RawMessage")
+		}
+		return v.Interface(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, fmt.Errorf("cannot marshal nil %s", v.Type())
+		}
+		return marshalValue(v.Elem())
+
+	case reflect.String:
+		return v.String(), nil
+
+	case reflect.Bool:
+		if v.Bool() {
+			return int64(1), nil
+		}
+		return int64(0), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > 1<<63-1 {
+			return nil, fmt.Errorf("cannot marshal %d: overflows int64", u)
+		}
+		return int64(u), nil
+
+	case reflect.Slice, reflect.Array:
+		// byte slices and arrays are bencode strings, not lists of integers
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice {
+				return v.Bytes(), nil
+			}
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return b, nil
+		}
+
+		list := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := marshalValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot marshal map with %s keys", v.Type().Key())
+		}
+
+		dict := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			item, err := marshalValue(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %v", iter.Key().String(), err)
+			}
+			dict[iter.Key().String()] = item
+		}
+		return dict, nil
+
+	case reflect.Struct:
+		dict := make(map[string]interface{})
+		for _, f := range structFields(v.Type()) {
+			fv := v.Field(f.index)
+			if f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			// a nil pointer or interface has no bencode representation, so it is left out
+			if (fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Interface) && fv.IsNil() {
+				continue
+			}
+
+			item, err := marshalValue(fv)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", f.name, err)
+			}
+			dict[f.name] = item
+		}
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("cannot marshal value of type %s", v.Type())
+}
@@ -0,0 +1,184 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type inner struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type outer struct {
+	Name     string           `bencode:"name"`
+	Count    int              `bencode:"count"`
+	Flag     bool             `bencode:"flag"`
+	Hash     [4]byte          `bencode:"hash"`
+	Data     []byte           `bencode:"data"`
+	Files    []inner          `bencode:"files"`
+	Ptr      *inner           `bencode:"ptr"`
+	Extra    map[string]int64 `bencode:"extra"`
+	Untagged uint16
+	Skipped  string `bencode:"-"`
+	hidden   string
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := outer{
+		Name:     "a",
+		Count:    -3,
+		Flag:     true,
+		Hash:     [4]byte{1, 2, 3, 4},
+		Data:     []byte("xyz"),
+		Files:    []inner{{Length: 1, Path: []string{"b", "c"}}, {Length: 2}},
+		Ptr:      &inner{Length: 5},
+		Extra:    map[string]int64{"z": 1, "a": 2},
+		Untagged: 7,
+		Skipped:  "skip",
+		hidden:   "hidden",
+	}
+
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keys are sorted, byte arrays are strings and "-" and unexported
+	// fields are left out
+	want := "d8:Untaggedi7e5:counti-3e4:data3:xyz5:extrad1:ai2e1:zi1ee5:filesld6:lengthi1e4:pathl1:b1:ceed6:lengthi2e4:pathleee4:flagi1e4:hash4:\x01\x02\x03\x044:name1:a3:ptrd6:lengthi5e4:pathleee"
+	if string(data) != want {
+		t.Errorf("Marshal = %q, want %q", data, want)
+	}
+
+	var out outer
+	err = Unmarshal(data, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped, in.hidden = "", ""
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unmarshal = %+v, want %+v", out, in)
+	}
+}
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	type options struct {
+		A string            `bencode:"a,omitempty"`
+		B int64             `bencode:"b,string,omitempty"`
+		C []string          `bencode:"c,omitempty,string"`
+		D map[string]string `bencode:",omitempty"`
+		E int64             `bencode:"e"`
+		F *inner            `bencode:"f"`
+	}
+
+	data, err := Marshal(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "d1:ei0ee" {
+		t.Errorf("Marshal of empty fields = %q, want %q", data, "d1:ei0ee")
+	}
+
+	data, err = Marshal(options{A: "x", B: 1, C: []string{"y"}, D: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "d1:Dd1:k1:ve1:a1:x1:bi1e1:cl1:ye1:ei0ee"
+	if string(data) != want {
+		t.Errorf("Marshal = %q, want %q", data, want)
+	}
+}
+
+func TestUnmarshalMaps(t *testing.T) {
+	var got map[string]interface{}
+	err := Unmarshal([]byte("d1:ai1e1:bl1:xi2ee1:cd1:yi3eee"), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": int64(1),
+		"b": []interface{}{"x", int64(2)},
+		"c": map[string]interface{}{"y": int64(3)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal = %#v, want %#v", got, want)
+	}
+
+	// unknown keys are skipped
+	var s struct {
+		A int `bencode:"a"`
+	}
+	err = Unmarshal([]byte("d1:ai1e1:bl1:xi2eee"), &s)
+	if err != nil || s.A != 1 {
+		t.Errorf("Unmarshal = %+v, %v", s, err)
+	}
+}
+
+func TestUnmarshalMismatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		v     interface{}
+	}{
+		{"string into int", "3:abc", new(int)},
+		{"integer into string", "i1e", new(string)},
+		{"list into struct", "le", new(inner)},
+		{"dictionary into slice", "de", new([]string)},
+		{"nested field", "d6:lengthi1e4:pathl1:ai2eee", new(inner)},
+		{"overflow", "i300e", new(int8)},
+		{"negative into uint", "i-1e", new(uint)},
+		{"wrong array length", "3:abc", new([4]byte)},
+		{"too many elements", "li1ei2ee", new([1]int)},
+		{"non-string map keys", "d1:ai1ee", new(map[int]int)},
+	}
+
+	for _, test := range tests {
+		err := Unmarshal([]byte(test.input), test.v)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: Unmarshal(%q) = %v, want a SyntaxError", test.name, test.input, err)
+		}
+	}
+
+	invalid := []interface{}{
+		nil,
+		map[int]string{1: "a"},
+		struct{ C chan int }{make(chan int)},
+		RawMessage{},
+		uint64(1 << 63),
+	}
+	for _, v := range invalid {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("Marshal(%#v) succeeded", v)
+		}
+	}
+}
+
+func TestRawMessage(t *testing.T) {
+	// the raw value keeps its unsorted keys and leading zeros, and is
+	// written back unchanged
+	raw := "d1:bi01e1:ad1:zle1:y03:abcee"
+	input := "d1:ai1e4:info" + raw + "1:zi2ee"
+
+	var v struct {
+		A    int64      `bencode:"a"`
+		Info RawMessage `bencode:"info"`
+		Z    int64      `bencode:"z"`
+	}
+	err := Unmarshal([]byte(input), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.Info) != raw || v.A != 1 || v.Z != 2 {
+		t.Errorf("Unmarshal = %+v, want info %q", v, raw)
+	}
+
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != input {
+		t.Errorf("Marshal = %q, want %q", data, input)
+	}
+}
@@ -0,0 +1,11 @@
+package bencode
+
+import "reflect"
+
+// RawMessage is a raw encoded bencode value. Unmarshal fills it with the
+// exact bytes of the value from the input instead of decoding them, and
+// Marshal/Encode write it back out unchanged. Use it where the original
+// encoding matters, such as hashing a torrent's info dictionary.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
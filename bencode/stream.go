@@ -0,0 +1,272 @@
+package bencode
+
+import (
+	"fmt"
+	"io"
+)
+
+type TokenKind uint8
+
+const (
+	DictStart TokenKind = iota
+	ListStart
+	Int
+	String
+	End
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case DictStart:
+		return "DictStart"
+	case ListStart:
+		return "ListStart"
+	case Int:
+		return "Int"
+	case String:
+		return "String"
+	case End:
+		return "End"
+	}
+	return fmt.Sprintf("TokenKind(%d)", uint8(k))
+}
+
+// Token is a single lexical element of a bencoded stream. Int holds the value
+// of an Int token and Bytes the contents of a String token; dictionary keys
+// are reported as String tokens.
+type Token struct {
+	Kind  TokenKind
+	Int   int64
+	Bytes []byte
+}
+
+// container tracks an open list or dictionary during token-level reading and
+// writing. For dictionaries, key reports whether the next element is a key,
+// and lastKey holds the previous key once one was read, for Strict checks.
+type container struct {
+	dict    bool
+	key     bool
+	hasKey  bool
+	lastKey string
+}
+
+// Token returns the next token in the input stream. At the end of the input,
+// outside any list or dictionary, it returns io.EOF. Token and Decode may be
+// mixed: after reading a dictionary key with Token, Decode reads its value.
+func (d *Decoder) Token() (Token, error) {
+	if len(d.tokens) == 0 {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			return Token{}, io.EOF
+		}
+	}
+
+	firstByte, err := d.peek()
+	if err != nil {
+		return Token{}, err
+	}
+
+	var top *container
+	if len(d.tokens) > 0 {
+		top = &d.tokens[len(d.tokens)-1]
+	}
+
+	if firstByte == 'e' {
+		if top == nil {
+			return Token{}, d.errorf("unexpected end of container")
+		}
+		if top.dict && !top.key {
+			return Token{}, d.errorf("dictionary key without a value")
+		}
+		d.r.ReadByte()
+		d.tokens = d.tokens[:len(d.tokens)-1]
+		d.depth--
+		d.valueDone()
+		if err := d.checkTrailing(); err != nil {
+			return Token{}, err
+		}
+		return Token{Kind: End}, nil
+	}
+
+	if top != nil && top.dict && top.key && (firstByte < '0' || firstByte > '9') {
+		return Token{}, d.errorf("dictionary key must be a string, got %q", firstByte)
+	}
+
+	switch {
+	case firstByte == 'i':
+		n, err := d.decodeInt()
+		if err != nil {
+			return Token{}, err
+		}
+		d.valueDone()
+		if err := d.checkTrailing(); err != nil {
+			return Token{}, err
+		}
+		return Token{Kind: Int, Int: n}, nil
+
+	case firstByte >= '0' && firstByte <= '9':
+		b, err := d.decodeBytes()
+		if err != nil {
+			return Token{}, err
+		}
+		if top != nil && top.dict && top.key {
+			err = d.checkKey(top, string(b))
+			if err != nil {
+				return Token{}, err
+			}
+		}
+		d.valueDone()
+		if err := d.checkTrailing(); err != nil {
+			return Token{}, err
+		}
+		return Token{Kind: String, Bytes: b}, nil
+
+	case firstByte == 'l' || firstByte == 'd':
+		err := d.enter()
+		if err != nil {
+			return Token{}, err
+		}
+		d.r.ReadByte()
+		d.tokens = append(d.tokens, container{dict: firstByte == 'd', key: true})
+		if firstByte == 'd' {
+			return Token{Kind: DictStart}, nil
+		}
+		return Token{Kind: ListStart}, nil
+	}
+
+	return Token{}, d.errorf("invalid bencode type: got %q", firstByte)
+}
+
+// checkKey enforces the Strict ordering of dictionary keys read as tokens,
+// the same rule decodeDictEntries applies.
+func (d *Decoder) checkKey(top *container, key string) error {
+	if d.Strict && top.hasKey {
+		if key == top.lastKey {
+			return d.errorf("duplicate dictionary key %q", key)
+		}
+		if key < top.lastKey {
+			return d.errorf("dictionary key %q is not sorted after %q", key, top.lastKey)
+		}
+	}
+	top.hasKey = true
+	top.lastKey = key
+	return nil
+}
+
+// checkTrailing rejects input following a complete top-level value in Strict
+// mode.
+func (d *Decoder) checkTrailing() error {
+	if !d.Strict || len(d.tokens) > 0 {
+		return nil
+	}
+	if _, err := d.r.Peek(1); err != io.EOF {
+		return d.errorf("trailing data after value")
+	}
+	return nil
+}
+
+// valueDone records that a complete element was read in the innermost open
+// dictionary, alternating between key and value positions.
+func (d *Decoder) valueDone() {
+	if len(d.tokens) == 0 {
+		return
+	}
+	top := &d.tokens[len(d.tokens)-1]
+	if top.dict {
+		top.key = !top.key
+	}
+}
+
+// Encoder writes bencoded values to an output stream, either as whole values
+// with Encode or incrementally with WriteToken. Dictionary keys written as
+// tokens must already be in sorted order.
+type Encoder struct {
+	w      io.Writer
+	tokens []container
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the bencoding of v as the next element of the stream. In a
+// dictionary opened with WriteToken, keys may be written with Encode as a
+// string or []byte.
+func (e *Encoder) Encode(v interface{}) error {
+	if e.atKey() {
+		switch v.(type) {
+		case string, []byte:
+		default:
+			return fmt.Errorf("dictionary key must be a string, got %T", v)
+		}
+	}
+
+	err := Encode(e.w, v)
+	if err != nil {
+		return err
+	}
+
+	e.valueDone()
+	return nil
+}
+
+func (e *Encoder) WriteToken(t Token) error {
+	if e.atKey() && t.Kind != String && t.Kind != End {
+		return fmt.Errorf("dictionary key must be a string, got %s", t.Kind)
+	}
+
+	var err error
+	switch t.Kind {
+	case DictStart, ListStart:
+		if t.Kind == DictStart {
+			_, err = e.w.Write([]byte("d"))
+		} else {
+			_, err = e.w.Write([]byte("l"))
+		}
+		if err != nil {
+			return err
+		}
+		e.tokens = append(e.tokens, container{dict: t.Kind == DictStart, key: true})
+		return nil
+
+	case End:
+		if len(e.tokens) == 0 {
+			return fmt.Errorf("end token without an open list or dictionary")
+		}
+		if top := e.tokens[len(e.tokens)-1]; top.dict && !top.key {
+			return fmt.Errorf("dictionary key without a value")
+		}
+		_, err = e.w.Write([]byte("e"))
+		if err != nil {
+			return err
+		}
+		e.tokens = e.tokens[:len(e.tokens)-1]
+
+	case Int:
+		err = encodeInt(e.w, t.Int)
+	case String:
+		err = encodeBytes(e.w, t.Bytes)
+	default:
+		return fmt.Errorf("unknown token kind %s", t.Kind)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	e.valueDone()
+	return nil
+}
+
+func (e *Encoder) atKey() bool {
+	return len(e.tokens) > 0 && e.tokens[len(e.tokens)-1].dict && e.tokens[len(e.tokens)-1].key
+}
+
+func (e *Encoder) valueDone() {
+	if len(e.tokens) == 0 {
+		return
+	}
+	top := &e.tokens[len(e.tokens)-1]
+	if top.dict {
+		top.key = !top.key
+	}
+}
@@ -0,0 +1,121 @@
+package bencode
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	inputs := []string{
+		"i-3e",
+		"3:abc",
+		"le",
+		"d1:ad1:xi1e1:yl0:i2eee1:bli1ed1:zleeee",
+	}
+
+	for _, input := range inputs {
+		decoder := NewDecoder(bytes.NewReader([]byte(input)))
+		var buf bytes.Buffer
+		encoder := NewEncoder(&buf)
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Token(%q) failed: %v", input, err)
+			}
+			err = encoder.WriteToken(token)
+			if err != nil {
+				t.Fatalf("WriteToken(%v) for %q failed: %v", token.Kind, input, err)
+			}
+		}
+		if buf.String() != input {
+			t.Errorf("tokens of %q written back as %q", input, buf.String())
+		}
+	}
+}
+
+func TestTokenThenDecode(t *testing.T) {
+	decoder := NewDecoder(bytes.NewReader([]byte("d1:ad1:xi1ee1:bli2eee")))
+
+	var kinds []TokenKind
+	var values []map[string]int64
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinds = append(kinds, token.Kind)
+
+		// the value of key a is decoded whole, the rest as tokens
+		if token.Kind == String && string(token.Bytes) == "a" {
+			var v map[string]int64
+			err = decoder.Decode(&v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+	}
+
+	want := []TokenKind{DictStart, String, String, ListStart, Int, End, End}
+	if len(kinds) != len(want) {
+		t.Fatalf("got tokens %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("got tokens %v, want %v", kinds, want)
+		}
+	}
+	if len(values) != 1 || values[0]["x"] != 1 {
+		t.Errorf("got decoded values %v", values)
+	}
+}
+
+func TestEncoderKeys(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := NewEncoder(&buf)
+
+	steps := []func() error{
+		func() error { return encoder.WriteToken(Token{Kind: DictStart}) },
+		func() error { return encoder.Encode("a") },
+		func() error { return encoder.Encode([]interface{}{int64(1)}) },
+		func() error { return encoder.Encode([]byte("b")) },
+		func() error { return encoder.Encode(map[string]interface{}{"x": "y"}) },
+		func() error { return encoder.WriteToken(Token{Kind: String, Bytes: []byte("c")}) },
+		func() error { return encoder.Encode(int64(2)) },
+		func() error { return encoder.WriteToken(Token{Kind: End}) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+	want := "d1:ali1ee1:bd1:x1:ye1:ci2ee"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	// anything but a string at a key position is rejected
+	encoder = NewEncoder(io.Discard)
+	encoder.WriteToken(Token{Kind: DictStart})
+	for _, key := range []interface{}{int64(1), []interface{}{}, map[string]interface{}{}} {
+		if err := encoder.Encode(key); err == nil {
+			t.Errorf("Encode(%#v) at a key succeeded", key)
+		}
+	}
+	if err := encoder.WriteToken(Token{Kind: Int, Int: 1}); err == nil {
+		t.Error("WriteToken(Int) at a key succeeded")
+	}
+	if err := encoder.WriteToken(Token{Kind: String, Bytes: []byte("k")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.WriteToken(Token{Kind: End}); err == nil {
+		t.Error("closing a dictionary after a key succeeded")
+	}
+}
@@ -0,0 +1,218 @@
+package bencode
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// Unmarshal decodes the bencoded data into the value pointed to by v.
+// Dictionary keys are matched against struct fields using their `bencode`
+// tags; keys without a matching field are skipped.
+func Unmarshal(data []byte, v interface{}) error {
+	return NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (d *Decoder) unmarshalValue(v reflect.Value) error {
+	firstByte, err := d.peek()
+	if err != nil {
+		return err
+	}
+	kind := kindOf(firstByte)
+	if kind == "" {
+		return d.errorf("invalid bencode type: got %q", firstByte)
+	}
+
+	if v.Type() == rawMessageType {
+		raw, err := d.captureRaw()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(raw)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.unmarshalValue(v.Elem())
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			break
+		}
+		val, err := d.decodeRecursive()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(val))
+		return nil
+
+	case reflect.String:
+		if kind != "string" {
+			break
+		}
+		s, err := d.decodeString()
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+		return nil
+
+	case reflect.Bool:
+		if kind != "integer" {
+			break
+		}
+		n, err := d.decodeInt()
+		if err != nil {
+			return err
+		}
+		v.SetBool(n != 0)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if kind != "integer" {
+			break
+		}
+		n, err := d.decodeInt()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return d.errorf("integer %d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if kind != "integer" {
+			break
+		}
+		n, err := d.decodeInt()
+		if err != nil {
+			return err
+		}
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return d.errorf("integer %d overflows %s", n, v.Type())
+		}
+		v.SetUint(uint64(n))
+		return nil
+
+	case reflect.Slice:
+		if kind == "string" && v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.decodeBytes()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		if kind != "list" {
+			break
+		}
+		return d.unmarshalList(v)
+
+	case reflect.Array:
+		if kind == "string" && v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.decodeBytes()
+			if err != nil {
+				return err
+			}
+			if len(b) != v.Len() {
+				return d.errorf("cannot unmarshal %d byte string into %s", len(b), v.Type())
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+		if kind != "list" {
+			break
+		}
+		return d.unmarshalList(v)
+
+	case reflect.Map:
+		if kind != "dictionary" {
+			break
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return d.errorf("cannot unmarshal dictionary into %s", v.Type())
+		}
+		return d.unmarshalMap(v)
+
+	case reflect.Struct:
+		if kind != "dictionary" {
+			break
+		}
+		return d.unmarshalStruct(v)
+
+	default:
+		return d.errorf("cannot unmarshal into value of type %s", v.Type())
+	}
+
+	return d.errorf("cannot unmarshal %s into %s", kind, v.Type())
+}
+
+func (d *Decoder) unmarshalList(v reflect.Value) error {
+	if v.Kind() == reflect.Slice {
+		v.SetLen(0)
+	}
+
+	return d.decodeListEntries(func(i int) error {
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		} else if i >= v.Len() {
+			return d.errorf("list has more than %d elements for %s", v.Len(), v.Type())
+		}
+
+		return d.unmarshalValue(v.Index(i))
+	})
+}
+
+func (d *Decoder) unmarshalMap(v reflect.Value) error {
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+
+	return d.decodeDictEntries(func(key string) error {
+		elem := reflect.New(v.Type().Elem()).Elem()
+		err := d.unmarshalValue(elem)
+		if err != nil {
+			return err
+		}
+
+		v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		return nil
+	})
+}
+
+func (d *Decoder) unmarshalStruct(v reflect.Value) error {
+	fields := structFields(v.Type())
+
+	return d.decodeDictEntries(func(key string) error {
+		for _, f := range fields {
+			if f.name == key {
+				return d.unmarshalValue(v.Field(f.index))
+			}
+		}
+
+		// unknown keys are decoded and thrown away
+		_, err := d.decodeRecursive()
+		return err
+	})
+}
+
+// kindOf names the bencode type introduced by the given byte, for use in
+// error messages. It returns "" if the byte does not start a value.
+func kindOf(b byte) string {
+	switch {
+	case b == 'i':
+		return "integer"
+	case b >= '0' && b <= '9':
+		return "string"
+	case b == 'l':
+		return "list"
+	case b == 'd':
+		return "dictionary"
+	}
+	return ""
+}
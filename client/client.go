@@ -1,6 +1,8 @@
 package client
 
 import (
+	"bitTorrentClient/peers"
+	"bitTorrentClient/storage"
 	"bitTorrentClient/torrent"
 	"bytes"
 	"crypto/sha1"
@@ -22,64 +24,168 @@ type Client struct {
 	Address  string
 	Bitfield BitField
 
-	// the following fields will be used for downloading pieces
-	DataBuffer  []byte
+	// the following fields will be used for downloading pieces; blocks are
+	// gathered in pieceBuffer and written to Storage once the piece verifies
+	Storage     storage.Storage
 	PieceLength int
+	TotalLength int // the size of the torrent, needed to serve the last piece
+	pieceBuffer []byte
 	CurrentWork *torrent.PieceWork
-	WorkQueue   chan *torrent.PieceWork
+	WorkQueue   *torrent.WorkQueue
 	Requested   int
 	Downloaded  int
+
+	// Stats, if set, is credited with every verified piece and every block
+	// uploaded.
+	Stats *torrent.Stats
+
+	// Have, if set, records the pieces we verified. Peers are told about
+	// them and may request them.
+	Have *torrent.Progress
+
+	// Metadata is the raw info dictionary, served to peers asking for it
+	// with ut_metadata. It may be nil.
+	Metadata []byte
+
+	// the extension protocol (BEP 10): the messages we support, the port we
+	// accept connections on, and the peer's extension handshake once
+	// received. PEX sends from its own goroutine, so the handshake is guarded
+	// by extMu.
+	Extensions     *Extensions
+	ListenPort     uint16
+	extMu          sync.Mutex
+	PeerExtensions *ExtendedHandshake
+
+	// Pool, if set, is told about the connection and, if its PEX is on,
+	// receives the peers learned through peer exchange (ut_pex).
+	Pool *PeerPool
+
+	// whether we choke the peer and whether it wants our pieces
+	amChoking      bool
+	peerInterested bool
+
+	done        chan struct{}
+	pexStarted  bool
+	pexSent     map[string]peers.Peer
+	pexReceived time.Time
 }
 
-func New(infohash [20]byte, address string, peerId [20]byte, dataBuffer []byte, pieceLength int, workQueue chan *torrent.PieceWork, waitgroup *sync.WaitGroup) *Client {
+// DefaultListenPort is the port announced to trackers and peers.
+const DefaultListenPort = 6881
+
+func New(infohash [20]byte, address string, peerId [20]byte, store storage.Storage, pieceLength int, workQueue *torrent.WorkQueue, waitgroup *sync.WaitGroup) *Client {
 	return &Client{
 		wg: waitgroup,
 
-		Choked:   true, // our peer starts in a choked state already
-		InfoHash: infohash,
-		PeerId:   peerId,
-		Address:  address,
+		Choked:    true, // our peer starts in a choked state already
+		amChoking: true,
+		InfoHash:  infohash,
+		PeerId:    peerId,
+		Address:   address,
 
 		// this is used for dowloading the different pieces
-		DataBuffer:  dataBuffer,
+		Storage:     store,
 		PieceLength: pieceLength,
 		WorkQueue:   workQueue,
+
+		Extensions: DefaultExtensions,
+		ListenPort: DefaultListenPort,
+
+		done:    make(chan struct{}),
+		pexSent: make(map[string]peers.Peer),
 	}
 }
 
 func (c *Client) Run() error {
 	defer c.wg.Done()
-	handshake := &Handshake{
-		Pstr:     "BitTorrent protocol",
-		InfoHash: c.InfoHash,
-		PeerID:   c.PeerId,
+	defer close(c.done)
+	if c.Pool != nil {
+		defer c.Pool.Done(c.Address)
 	}
 
-	serializedMsg := handshake.Serialize()
-
 	conn, err := net.DialTimeout("tcp", c.Address, 5*time.Second)
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
 	c.Conn = conn
 	fmt.Printf("peer %s: connected\n", c.Address)
-	conn.Write(serializedMsg)
 
-	handshakeResp := make([]byte, 68)
+	peerHandshake, err := exchangeHandshake(conn, c.InfoHash, c.PeerId)
+
+	if err != nil {
+		return err
+	}
+	fmt.Printf("peer %s: handshake OK\n", c.Address)
+	if c.Pool != nil {
+		c.Pool.Connected(c.Address)
+	}
+
+	return c.run(peerHandshake)
+}
 
-	_, err = io.ReadFull(conn, handshakeResp)
+// Accept runs a connection the peer opened to us, once its handshake was read
+// (see Listener). It answers with our handshake.
+func (c *Client) Accept(conn net.Conn, peerHandshake *Handshake) error {
+	defer c.wg.Done()
+	defer close(c.done)
+	if c.Pool != nil {
+		defer c.Pool.Done(c.Address)
+	}
+	defer conn.Close()
 
+	c.Conn = conn
+	fmt.Printf("peer %s: incoming connection\n", c.Address)
+
+	err := sendHandshake(conn, c.InfoHash, c.PeerId)
 	if err != nil {
 		return err
 	}
 	fmt.Printf("peer %s: handshake OK\n", c.Address)
 
-	// Send interested message to let peer know we want pieces
-	interestedMsg := &Message{ID: MsgInterested, Payload: []byte{}}
-	interestedSerialized := interestedMsg.Serialize()
-	conn.Write(interestedSerialized)
-	fmt.Printf("peer %s: interested sent\n", c.Address)
+	return c.run(peerHandshake)
+}
+
+// run exchanges messages with the peer after the handshakes, downloading
+// the pieces of the work queue and serving the ones we have.
+func (c *Client) run(peerHandshake *Handshake) error {
+	conn := c.Conn
+	defer c.releaseWork()
+
+	if peerHandshake.SupportsExtensions() {
+		err := c.sendExtendedHandshake()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Have != nil {
+		// subscribe before taking the bitfield, so no piece verified in
+		// between is missed
+		haves, unsubscribe := c.Have.Subscribe()
+		defer unsubscribe()
+
+		bitfield := c.Have.Bitfield()
+		if c.Have.Count() > 0 {
+			bitfieldMsg := &Message{ID: MsgBitfield, Payload: bitfield}
+			_, err := conn.Write(bitfieldMsg.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+
+		go c.sendHaves(haves, bitfield)
+	}
+
+	// Send interested message to let peer know we want pieces, unless we
+	// are only seeding
+	if c.Have == nil || !c.Have.Done() {
+		interestedMsg := &Message{ID: MsgInterested, Payload: []byte{}}
+		interestedSerialized := interestedMsg.Serialize()
+		conn.Write(interestedSerialized)
+		fmt.Printf("peer %s: interested sent\n", c.Address)
+	}
 
 	for {
 		message, err := Read(conn)
@@ -91,6 +197,11 @@ func (c *Client) Run() error {
 			return err
 		}
 
+		// pieces put back by other peers are picked up by idle ones
+		if !c.Choked && c.CurrentWork == nil && c.nextWork() {
+			c.requestNextBlock()
+		}
+
 		if message == nil {
 			continue
 		}
@@ -103,28 +214,55 @@ func (c *Client) Run() error {
 			c.Choked = false
 			fmt.Printf("peer %s: unchoked\n", c.Address)
 
-			if c.CurrentWork == nil {
-				c.CurrentWork = <-c.WorkQueue
-				fmt.Printf("peer %s: assigned piece #%d len=%d\n", c.Address, c.CurrentWork.Index, c.CurrentWork.Length)
+			if c.CurrentWork == nil && c.nextWork() {
 				c.requestNextBlock()
 			}
 
+		case MsgInterested:
+			c.peerInterested = true
+			if c.amChoking && c.Have != nil {
+				err := c.sendUnchoke()
+				if err != nil {
+					return err
+				}
+			}
+
+		case MsgNotInterested:
+			c.peerInterested = false
+
+		case MsgHave:
+			if len(message.Payload) == 4 {
+				index := int(binary.BigEndian.Uint32(message.Payload))
+				if index/8 < len(c.Bitfield) {
+					c.Bitfield.SetPiece(index)
+				}
+			}
+
+		case MsgRequest:
+			err := c.serveRequest(message.Payload)
+			if err != nil {
+				fmt.Printf("peer %s: request failed: %v\n", c.Address, err)
+			}
+
 		case MsgBitfield:
 			c.Bitfield = message.Payload
 			fmt.Printf("peer %s: bitfield %d bytes\n", c.Address, len(message.Payload))
 
 		case MsgPiece:
-			if len(message.Payload) < 8 {
+			if len(message.Payload) < 8 || c.CurrentWork == nil {
 				continue
 			}
 
 			index := binary.BigEndian.Uint32(message.Payload[0:4])
 			begin := binary.BigEndian.Uint32(message.Payload[4:8])
-
-			offset := (int(index) * c.PieceLength) + int(begin)
 			data := message.Payload[8:]
 
-			copy(c.DataBuffer[offset:], data)
+			if int(index) != c.CurrentWork.Index || int(begin)+len(data) > len(c.pieceBuffer) {
+				fmt.Printf("peer %s: unexpected block index=%d begin=%d bytes=%d\n", c.Address, index, begin, len(data))
+				continue
+			}
+
+			copy(c.pieceBuffer[begin:], data)
 			fmt.Printf("copy_ok peer=%s index=%d begin=%d bytes=%d\n", c.Address, index, begin, len(data))
 
 			c.Downloaded++
@@ -136,22 +274,32 @@ func (c *Client) Run() error {
 				fmt.Printf("piece_done peer=%s index=%d verifying\n", c.Address, c.CurrentWork.Index)
 
 				// VERIFY THE HASH
-				pieceData := c.DataBuffer[c.CurrentWork.Index*c.PieceLength : (c.CurrentWork.Index*c.PieceLength)+c.CurrentWork.Length]
-				hash := sha1.Sum(pieceData)
+				hash := sha1.Sum(c.pieceBuffer)
 
 				if bytes.Equal(hash[:], c.CurrentWork.Hash[:]) {
 					fmt.Printf("piece_valid peer=%s index=%d\n", c.Address, c.CurrentWork.Index)
+					err := c.savePiece()
+					if err != nil {
+						return err
+					}
+					if c.Stats != nil {
+						c.Stats.Downloaded.Add(int64(c.CurrentWork.Length))
+					}
+					if c.Have != nil {
+						c.Have.Set(c.CurrentWork.Index)
+					}
 
 					// Get the next job
-					c.CurrentWork = <-c.WorkQueue
+					c.CurrentWork = nil
 					c.Requested = 0
 					c.Downloaded = 0
-					fmt.Printf("peer %s: assigned piece #%d len=%d\n", c.Address, c.CurrentWork.Index, c.CurrentWork.Length)
-					c.requestNextBlock()
+					if c.nextWork() {
+						c.requestNextBlock()
+					}
 
 				} else {
 					fmt.Printf("piece_invalid peer=%s index=%d requeue\n", c.Address, c.CurrentWork.Index)
-					c.WorkQueue <- c.CurrentWork
+					c.WorkQueue.Put(c.CurrentWork)
 					c.CurrentWork = nil // Become idle
 					c.Downloaded = 0
 					c.Requested = 0
@@ -159,11 +307,51 @@ func (c *Client) Run() error {
 			} else {
 				c.requestNextBlock()
 			}
+
+		case MsgExtended:
+			err := c.handleExtended(message.Payload)
+			if err != nil {
+				fmt.Printf("peer %s: extended message error: %v\n", c.Address, err)
+			}
 		default:
 		}
 	}
 }
 
+// releaseWork puts an unfinished piece back for the other peers.
+func (c *Client) releaseWork() {
+	if c.CurrentWork != nil {
+		c.WorkQueue.Put(c.CurrentWork)
+		c.CurrentWork = nil
+	}
+}
+
+// savePiece writes the verified current piece to storage.
+func (c *Client) savePiece() error {
+	_, err := c.Storage.WriteAt(c.pieceBuffer, c.CurrentWork.Index, 0)
+	if err != nil {
+		return fmt.Errorf("error while writing piece #%d: %v", c.CurrentWork.Index, err)
+	}
+	return c.Storage.MarkComplete(c.CurrentWork.Index)
+}
+
+// nextWork takes the next piece from the work queue without waiting, so
+// the connection keeps serving the peer while there is nothing to download.
+func (c *Client) nextWork() bool {
+	work, ok := c.WorkQueue.Take()
+	if !ok {
+		return false
+	}
+
+	c.CurrentWork = work
+	if cap(c.pieceBuffer) < work.Length {
+		c.pieceBuffer = make([]byte, work.Length)
+	}
+	c.pieceBuffer = c.pieceBuffer[:work.Length]
+	fmt.Printf("peer %s: assigned piece #%d len=%d\n", c.Address, c.CurrentWork.Index, c.CurrentWork.Length)
+	return true
+}
+
 func (c *Client) sendRequest(index, begin, length int32) error {
 	payload := make([]byte, 12)
 
@@ -205,7 +393,7 @@ func (c *Client) requestNextBlock() {
 	err := c.sendRequest(int32(c.CurrentWork.Index), int32(begin), int32(length))
 
 	if err != nil {
-		c.WorkQueue <- c.CurrentWork
+		c.WorkQueue.Put(c.CurrentWork)
 		c.CurrentWork = nil
 		c.Requested = 0
 		c.Downloaded = 0
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"bitTorrentClient/bencode"
+)
+
+// extHandshakeID is the extended message ID of the extension handshake; every
+// other ID is assigned by the receiving side in its handshake's m dictionary.
+const extHandshakeID = 0
+
+const (
+	// clientVersion is sent as v in our extension handshake.
+	clientVersion = "bitTorrentClient 0.1"
+	// maxOutstandingRequests is sent as reqq, the number of requests we
+	// queue from a peer without dropping any.
+	maxOutstandingRequests = 250
+)
+
+// ExtendedHandshake is the payload of the extension protocol handshake
+// (BEP 10).
+type ExtendedHandshake struct {
+	M            map[string]int64 `bencode:"m"`
+	V            string           `bencode:"v,omitempty"`
+	P            int64            `bencode:"p,omitempty"`
+	Reqq         int64            `bencode:"reqq,omitempty"`
+	YourIP       []byte           `bencode:"yourip,omitempty"`
+	MetadataSize int64            `bencode:"metadata_size,omitempty"`
+}
+
+// ExtensionHandler is called with the payload of every extended message the
+// peer sends for the extension it was registered under. payload[0] is the
+// extended message ID and the rest is the message body.
+type ExtensionHandler func(c *Client, payload []byte) error
+
+// Extensions is the set of extension messages we support, mapping each name
+// to the local message ID advertised in our handshake and its handler.
+type Extensions struct {
+	mu       sync.RWMutex
+	ids      map[string]byte
+	handlers map[byte]ExtensionHandler
+}
+
+// DefaultExtensions is used by clients created with New. Features register
+// their handlers on it before any client runs.
+var DefaultExtensions = NewExtensions()
+
+// NewExtensions returns a registry with the extensions built into this
+// package (ut_metadata, ut_pex) already registered.
+func NewExtensions() *Extensions {
+	e := &Extensions{
+		ids:      make(map[string]byte),
+		handlers: make(map[byte]ExtensionHandler),
+	}
+	e.Register("ut_metadata", (*Client).serveMetadata)
+	e.Register("ut_pex", (*Client).handlePex)
+	return e
+}
+
+// Register adds a named extension and returns the local message ID peers will
+// use for it. Registering a name again replaces its handler.
+func (e *Extensions) Register(name string, handler ExtensionHandler) byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id, ok := e.ids[name]
+	if !ok {
+		if len(e.ids) == 255 {
+			panic("client: too many extensions registered")
+		}
+		id = byte(len(e.ids) + 1)
+		e.ids[name] = id
+	}
+
+	e.handlers[id] = handler
+	return id
+}
+
+func (e *Extensions) handler(id byte) ExtensionHandler {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.handlers[id]
+}
+
+// m returns the m dictionary for our extension handshake.
+func (e *Extensions) m() map[string]int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	res := make(map[string]int64, len(e.ids))
+	for name, id := range e.ids {
+		res[name] = int64(id)
+	}
+	return res
+}
+
+// Names lists the registered extension names in sorted order.
+func (e *Extensions) Names() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var names []string
+	for name := range e.ids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SupportsExtension reports whether the peer advertised the named extension
+// in its extension handshake.
+func (c *Client) SupportsExtension(name string) bool {
+	_, ok := c.peerExtensionID(name)
+	return ok
+}
+
+func (c *Client) peerExtensionID(name string) (byte, bool) {
+	c.extMu.Lock()
+	defer c.extMu.Unlock()
+
+	if c.PeerExtensions == nil {
+		return 0, false
+	}
+
+	// an ID of 0 means the peer disabled the extension
+	id, ok := c.PeerExtensions.M[name]
+	if !ok || id <= 0 || id > 255 {
+		return 0, false
+	}
+	return byte(id), true
+}
+
+// SendExtended sends a message of the named extension to the peer, using the
+// ID the peer assigned to it. dict is bencoded and followed by trailer.
+func (c *Client) SendExtended(name string, dict interface{}, trailer []byte) error {
+	id, ok := c.peerExtensionID(name)
+	if !ok {
+		return fmt.Errorf("peer %s does not support %s", c.Address, name)
+	}
+
+	message, err := newExtendedMessage(id, dict, trailer)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Conn.Write(message.Serialize())
+	return err
+}
+
+func (c *Client) sendExtendedHandshake() error {
+	m := c.Extensions.m()
+	if !c.pexEnabled() {
+		delete(m, "ut_pex")
+	}
+
+	handshake := ExtendedHandshake{
+		M:            m,
+		V:            clientVersion,
+		P:            int64(c.ListenPort),
+		Reqq:         maxOutstandingRequests,
+		MetadataSize: int64(len(c.Metadata)),
+	}
+
+	// tell the peer which address it connected from, so it can learn its
+	// external IP
+	if addr, ok := c.Conn.RemoteAddr().(*net.TCPAddr); ok {
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			handshake.YourIP = ip4
+		} else {
+			handshake.YourIP = addr.IP.To16()
+		}
+	}
+
+	message, err := newExtendedMessage(extHandshakeID, handshake, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Conn.Write(message.Serialize())
+	return err
+}
+
+func (c *Client) handleExtended(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("empty extended message")
+	}
+
+	if payload[0] == extHandshakeID {
+		var handshake ExtendedHandshake
+		_, err := decodeExtendedPayload(payload, &handshake)
+		if err != nil {
+			return err
+		}
+
+		c.extMu.Lock()
+		c.PeerExtensions = &handshake
+		c.extMu.Unlock()
+		fmt.Printf("peer %s: extensions %v client=%q reqq=%d\n", c.Address, handshake.M, handshake.V, handshake.Reqq)
+
+		if c.pexEnabled() && c.SupportsExtension("ut_pex") && !c.pexStarted {
+			c.pexStarted = true
+			go c.runPex()
+		}
+		return nil
+	}
+
+	handler := c.Extensions.handler(payload[0])
+	if handler == nil {
+		// the peer used an ID we never advertised
+		return nil
+	}
+	return handler(c, payload)
+}
+
+// newExtendedMessage builds a MsgExtended message carrying the bencoded dict,
+// followed by trailer if it is not empty.
+func newExtendedMessage(id byte, dict interface{}, trailer []byte) (*Message, error) {
+	encoded, err := bencode.Marshal(dict)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, 0, 1+len(encoded)+len(trailer))
+	payload = append(payload, id)
+	payload = append(payload, encoded...)
+	payload = append(payload, trailer...)
+
+	return &Message{ID: MsgExtended, Payload: payload}, nil
+}
+
+// decodeExtendedPayload decodes the bencoded dictionary of a MsgExtended
+// payload into v and returns whatever follows the dictionary.
+func decodeExtendedPayload(payload []byte, v interface{}) ([]byte, error) {
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("extended message too short")
+	}
+
+	decoder := bencode.NewDecoder(bytes.NewReader(payload[1:]))
+	err := decoder.Decode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload[1+decoder.InputOffset():], nil
+}
@@ -1,7 +1,21 @@
 package client
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+)
+
+const protocolString = "BitTorrent protocol"
+
+// extensionBit is set in Reserved[5] by peers supporting the extension
+// protocol (BEP 10).
+const extensionBit = 0x10
+
 type Handshake struct {
 	Pstr     string
+	Reserved [8]byte
 	InfoHash [20]byte
 	PeerID   [20]byte
 }
@@ -12,8 +26,71 @@ func (h *Handshake) Serialize() []byte {
 	buf[0] = byte(len(h.Pstr))
 	curr := 1
 	curr += copy(buf[curr:], h.Pstr)
-	curr += copy(buf[curr:], make([]byte, 8))
+	curr += copy(buf[curr:], h.Reserved[:])
 	curr += copy(buf[curr:], h.InfoHash[:])
 	curr += copy(buf[curr:], h.PeerID[:])
 	return buf
 }
+
+func (h *Handshake) SupportsExtensions() bool {
+	return h.Reserved[5]&extensionBit != 0
+}
+
+func ReadHandshake(r io.Reader) (*Handshake, error) {
+	lengthBuf := make([]byte, 1)
+	_, err := io.ReadFull(r, lengthBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	pstrlen := int(lengthBuf[0])
+	if pstrlen == 0 {
+		return nil, fmt.Errorf("handshake has an empty protocol string")
+	}
+
+	buf := make([]byte, pstrlen+48)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handshake{Pstr: string(buf[:pstrlen])}
+	curr := pstrlen
+	curr += copy(h.Reserved[:], buf[curr:])
+	curr += copy(h.InfoHash[:], buf[curr:])
+	copy(h.PeerID[:], buf[curr:])
+	return h, nil
+}
+
+// sendHandshake sends our handshake, advertising the extension protocol.
+func sendHandshake(conn net.Conn, infoHash, peerId [20]byte) error {
+	handshake := &Handshake{
+		Pstr:     protocolString,
+		InfoHash: infoHash,
+		PeerID:   peerId,
+	}
+	handshake.Reserved[5] |= extensionBit
+
+	_, err := conn.Write(handshake.Serialize())
+	return err
+}
+
+// exchangeHandshake sends our handshake and returns the peer's, which must be
+// for the same torrent.
+func exchangeHandshake(conn net.Conn, infoHash, peerId [20]byte) (*Handshake, error) {
+	err := sendHandshake(conn, infoHash, peerId)
+	if err != nil {
+		return nil, err
+	}
+
+	peerHandshake, err := ReadHandshake(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(peerHandshake.InfoHash[:], infoHash[:]) {
+		return nil, fmt.Errorf("peer handshake has info-hash %x, expected %x", peerHandshake.InfoHash, infoHash)
+	}
+
+	return peerHandshake, nil
+}
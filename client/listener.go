@@ -0,0 +1,105 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// handshakeTimeout bounds how long an incoming peer may take to send its
+// handshake.
+const handshakeTimeout = 10 * time.Second
+
+// AcceptFunc takes over an incoming connection whose handshake was for its
+// torrent. It owns conn from then on.
+type AcceptFunc func(conn net.Conn, peerHandshake *Handshake)
+
+// Listener accepts peer connections on our listen port and hands each to the
+// torrent its handshake names.
+type Listener struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	torrents map[[20]byte]AcceptFunc
+}
+
+// Listen opens the TCP port peers connect to.
+func Listen(port uint16) (*Listener, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Listener{
+		ln:       ln,
+		torrents: make(map[[20]byte]AcceptFunc),
+	}, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Register routes the connections for infoHash to accept.
+func (l *Listener) Register(infoHash [20]byte, accept AcceptFunc) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.torrents[infoHash] = accept
+}
+
+func (l *Listener) Unregister(infoHash [20]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.torrents, infoHash)
+}
+
+// Serve accepts connections until the listener is closed.
+func (l *Listener) Serve() error {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go l.route(conn)
+	}
+}
+
+func (l *Listener) Close() error {
+	return l.ln.Close()
+}
+
+// route reads the handshake of an incoming connection and hands it to its
+// torrent, or closes it if we do not have that torrent.
+func (l *Listener) route(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	peerHandshake, err := ReadHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if peerHandshake.Pstr != protocolString {
+		fmt.Printf("peer %s: unknown protocol %q\n", conn.RemoteAddr(), peerHandshake.Pstr)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	l.mu.Lock()
+	accept := l.torrents[peerHandshake.InfoHash]
+	l.mu.Unlock()
+
+	if accept == nil {
+		fmt.Printf("peer %s: handshake for unknown torrent %x\n", conn.RemoteAddr(), peerHandshake.InfoHash)
+		conn.Close()
+		return
+	}
+
+	accept(conn, peerHandshake)
+}
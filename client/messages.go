@@ -2,6 +2,7 @@ package client
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
@@ -17,6 +18,7 @@ const (
 	MsgRequest       messageID = 6
 	MsgPiece         messageID = 7
 	MsgCancel        messageID = 8
+	MsgExtended      messageID = 20
 )
 
 type Message struct {
@@ -52,21 +54,60 @@ func Read(r io.Reader) (*Message, error) {
 		return nil, nil
 	}
 
-	messageBuf := make([]byte, length)
-	_, messageBufErr := io.ReadFull(r, messageBuf)
+	// the length is the peer's to choose, so check it against the message
+	// type before allocating
+	idBuf := make([]byte, 1)
+	_, err = io.ReadFull(r, idBuf)
+	if err != nil {
+		return nil, err
+	}
+	id := messageID(idBuf[0])
+	if length > maxMessageLength(id) {
+		return nil, fmt.Errorf("message %d of %d bytes is too long", id, length)
+	}
 
-	if messageBufErr != nil {
-		return nil, messageBufErr
+	payload := make([]byte, length-1)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
 	}
 
 	m := Message{
-		ID:      messageID(messageBuf[0]),
-		Payload: messageBuf[1:],
+		ID:      id,
+		Payload: payload,
 	}
 
 	return &m, nil
 }
 
+const (
+	// maxBitfieldLength fits the bitfield of the largest torrent whose
+	// metadata we accept, at 20 bytes of piece hash per piece
+	maxBitfieldLength = 1 + (maxMetadataSize/20+7)/8
+
+	// maxExtendedLength fits a ut_metadata piece with its dictionary; extended
+	// handshakes and pex messages are far smaller
+	maxExtendedLength = 2 + metadataPieceSize + 16<<10
+
+	// maxOtherLength leaves room for messages of extensions we do not
+	// support; the ones we do are a few bytes
+	maxOtherLength = 1 << 10
+)
+
+// maxMessageLength returns the longest message of type id we read, ID byte
+// included.
+func maxMessageLength(id messageID) uint32 {
+	switch id {
+	case MsgPiece:
+		return 9 + maxRequestLength
+	case MsgBitfield:
+		return maxBitfieldLength
+	case MsgExtended:
+		return maxExtendedLength
+	}
+	return maxOtherLength
+}
+
 type BitField []byte
 
 func (bf BitField) HasPiece(index int) bool {
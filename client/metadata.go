@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// utMetadataID is the extended message ID FetchMetadata assigns to
+	// ut_metadata on its short-lived connections.
+	utMetadataID = 1
+
+	metadataPieceSize = 16384
+	// maxMetadataSize bounds what we will allocate for a peer's claimed
+	// metadata_size.
+	maxMetadataSize = 32 << 20
+)
+
+// ut_metadata message types (BEP 9)
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+type metadataMessage struct {
+	MsgType   int64 `bencode:"msg_type"`
+	Piece     int64 `bencode:"piece"`
+	TotalSize int64 `bencode:"total_size,omitempty"`
+}
+
+// FetchMetadata downloads the info dictionary of the torrent identified by
+// infoHash from the peer at address using ut_metadata (BEP 9). The returned
+// bytes hash to infoHash.
+func FetchMetadata(address string, infoHash, peerId [20]byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	peerHandshake, err := exchangeHandshake(conn, infoHash, peerId)
+	if err != nil {
+		return nil, err
+	}
+	if !peerHandshake.SupportsExtensions() {
+		return nil, fmt.Errorf("peer %s does not support the extension protocol", address)
+	}
+
+	ourHandshake := ExtendedHandshake{
+		M: map[string]int64{"ut_metadata": utMetadataID},
+		V: clientVersion,
+	}
+	message, err := newExtendedMessage(extHandshakeID, ourHandshake, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write(message.Serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata []byte
+	var received []bool
+	remaining := 0
+
+	for {
+		message, err := Read(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if message == nil || message.ID != MsgExtended || len(message.Payload) == 0 {
+			continue
+		}
+
+		switch message.Payload[0] {
+		case extHandshakeID:
+			var handshake ExtendedHandshake
+			_, err := decodeExtendedPayload(message.Payload, &handshake)
+			if err != nil {
+				return nil, fmt.Errorf("invalid extended handshake: %v", err)
+			}
+
+			peerID, ok := handshake.M["ut_metadata"]
+			if !ok || peerID <= 0 || peerID > 255 {
+				return nil, fmt.Errorf("peer %s does not support ut_metadata", address)
+			}
+			if handshake.MetadataSize <= 0 || handshake.MetadataSize > maxMetadataSize {
+				return nil, fmt.Errorf("peer %s reported invalid metadata_size %d", address, handshake.MetadataSize)
+			}
+
+			metadata = make([]byte, handshake.MetadataSize)
+			numPieces := (len(metadata) + metadataPieceSize - 1) / metadataPieceSize
+			received = make([]bool, numPieces)
+			remaining = numPieces
+
+			for piece := 0; piece < numPieces; piece++ {
+				request, err := newExtendedMessage(byte(peerID), metadataMessage{MsgType: metadataRequest, Piece: int64(piece)}, nil)
+				if err != nil {
+					return nil, err
+				}
+				_, err = conn.Write(request.Serialize())
+				if err != nil {
+					return nil, err
+				}
+			}
+			fmt.Printf("peer %s: requested %d metadata pieces (%d bytes)\n", address, numPieces, len(metadata))
+
+		case utMetadataID:
+			if metadata == nil {
+				continue
+			}
+
+			var msg metadataMessage
+			data, err := decodeExtendedPayload(message.Payload, &msg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ut_metadata message: %v", err)
+			}
+
+			switch msg.MsgType {
+			case metadataReject:
+				return nil, fmt.Errorf("peer %s rejected metadata piece %d", address, msg.Piece)
+			case metadataData:
+				if msg.Piece < 0 || int(msg.Piece) >= len(received) {
+					return nil, fmt.Errorf("peer %s sent out of range metadata piece %d", address, msg.Piece)
+				}
+
+				begin := int(msg.Piece) * metadataPieceSize
+				end := min(begin+metadataPieceSize, len(metadata))
+				if len(data) != end-begin {
+					return nil, fmt.Errorf("peer %s sent metadata piece %d with %d bytes, expected %d", address, msg.Piece, len(data), end-begin)
+				}
+
+				copy(metadata[begin:end], data)
+				if !received[msg.Piece] {
+					received[msg.Piece] = true
+					remaining--
+				}
+			}
+
+			if remaining == 0 {
+				hash := sha1.Sum(metadata)
+				if !bytes.Equal(hash[:], infoHash[:]) {
+					return nil, fmt.Errorf("metadata from peer %s does not match the info-hash", address)
+				}
+				fmt.Printf("peer %s: metadata complete\n", address)
+				return metadata, nil
+			}
+		}
+	}
+}
+
+// serveMetadata answers a ut_metadata request from the peer with the piece
+// of our info dictionary, or a reject if we do not have it.
+func (c *Client) serveMetadata(payload []byte) error {
+	var msg metadataMessage
+	_, err := decodeExtendedPayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+
+	if msg.MsgType != metadataRequest {
+		return nil
+	}
+
+	numPieces := (len(c.Metadata) + metadataPieceSize - 1) / metadataPieceSize
+
+	if msg.Piece < 0 || int(msg.Piece) >= numPieces {
+		return c.SendExtended("ut_metadata", metadataMessage{MsgType: metadataReject, Piece: msg.Piece}, nil)
+	}
+
+	begin := int(msg.Piece) * metadataPieceSize
+	end := min(begin+metadataPieceSize, len(c.Metadata))
+	return c.SendExtended("ut_metadata", metadataMessage{
+		MsgType:   metadataData,
+		Piece:     msg.Piece,
+		TotalSize: int64(len(c.Metadata)),
+	}, c.Metadata[begin:end])
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"bitTorrentClient/torrent"
+)
+
+// servePeer accepts one connection on a loopback listener, reads its
+// handshake and hands both to serve. It returns the listener's address.
+func servePeer(t *testing.T, serve func(conn net.Conn, peerHandshake *Handshake)) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	t.Cleanup(wg.Wait)
+	go func() {
+		defer wg.Done()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		peerHandshake, err := ReadHandshake(conn)
+		if err != nil {
+			return
+		}
+		serve(conn, peerHandshake)
+	}()
+	return listener.Addr().String()
+}
+
+// seedMetadata serves metadata with our own client, announcing infoHash.
+func seedMetadata(t *testing.T, infoHash [20]byte, metadata []byte) string {
+	t.Helper()
+
+	return servePeer(t, func(conn net.Conn, peerHandshake *Handshake) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		c := New(infoHash, conn.RemoteAddr().String(), [20]byte{9}, nil, 0, torrent.NewWorkQueue(nil), &wg)
+		c.Metadata = metadata
+		c.Accept(conn, peerHandshake)
+	})
+}
+
+func TestFetchMetadata(t *testing.T) {
+	// three pieces, the last one shorter
+	metadata := bytes.Repeat([]byte("0123456789"), 4000)
+	infoHash := sha1.Sum(metadata)
+
+	got, err := FetchMetadata(seedMetadata(t, infoHash, metadata), infoHash, [20]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, metadata) {
+		t.Errorf("got %d bytes of metadata, want the %d bytes served", len(got), len(metadata))
+	}
+}
+
+func TestFetchMetadataWrongHash(t *testing.T) {
+	// the peer serves metadata for another torrent under our info-hash
+	infoHash := sha1.Sum([]byte("other"))
+
+	_, err := FetchMetadata(seedMetadata(t, infoHash, []byte("d4:name1:ae")), infoHash, [20]byte{1})
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("got error %v, want a hash mismatch", err)
+	}
+}
+
+// metadataPeer answers the handshakes with an extension handshake claiming
+// size bytes of metadata, and then sends replies.
+func metadataPeer(t *testing.T, infoHash [20]byte, size int64, replies ...*Message) string {
+	t.Helper()
+
+	return servePeer(t, func(conn net.Conn, peerHandshake *Handshake) {
+		err := sendHandshake(conn, infoHash, [20]byte{9})
+		if err != nil {
+			return
+		}
+		handshake, err := newExtendedMessage(extHandshakeID, ExtendedHandshake{
+			M:            map[string]int64{"ut_metadata": 3},
+			MetadataSize: size,
+		}, nil)
+		if err != nil {
+			return
+		}
+		conn.Write(handshake.Serialize())
+		for _, reply := range replies {
+			conn.Write(reply.Serialize())
+		}
+
+		// wait for the fetching side to hang up
+		for {
+			if _, err := Read(conn); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestFetchMetadataRejected(t *testing.T) {
+	infoHash := [20]byte{1}
+
+	for _, size := range []int64{0, -1, maxMetadataSize + 1} {
+		_, err := FetchMetadata(metadataPeer(t, infoHash, size), infoHash, [20]byte{1})
+		if err == nil || !strings.Contains(err.Error(), "invalid metadata_size") {
+			t.Errorf("metadata_size %d: got error %v", size, err)
+		}
+	}
+
+	reject, err := newExtendedMessage(utMetadataID, metadataMessage{MsgType: metadataReject}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = FetchMetadata(metadataPeer(t, infoHash, 100, reject), infoHash, [20]byte{1})
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("got error %v, want a rejected piece", err)
+	}
+
+	// a piece of the wrong length is not copied in
+	short, err := newExtendedMessage(utMetadataID, metadataMessage{MsgType: metadataData}, []byte("short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = FetchMetadata(metadataPeer(t, infoHash, 100, short), infoHash, [20]byte{1})
+	if err == nil || !strings.Contains(err.Error(), "expected 100") {
+		t.Errorf("got error %v, want a piece of the wrong length", err)
+	}
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+const (
+	// pexInterval is how often we send a peer our added and dropped peers;
+	// BEP 11 allows at most one message a minute
+	pexInterval = time.Minute
+
+	// pexMinInterval is the shortest gap we accept between two PEX messages
+	// from a peer; faster ones are ignored
+	pexMinInterval = 45 * time.Second
+
+	// maxPexPeers bounds the added and the dropped peers of one message
+	maxPexPeers = 50
+
+	// pexReachable flags a peer we connected to ourselves
+	pexReachable = 0x10
+)
+
+// pexMessage is the body of a ut_pex message (BEP 11). Peers are compact,
+// with one flags byte per added peer.
+type pexMessage struct {
+	Added    []byte `bencode:"added,omitempty"`
+	AddedF   []byte `bencode:"added.f,omitempty"`
+	Added6   []byte `bencode:"added6,omitempty"`
+	Added6F  []byte `bencode:"added6.f,omitempty"`
+	Dropped  []byte `bencode:"dropped,omitempty"`
+	Dropped6 []byte `bencode:"dropped6,omitempty"`
+}
+
+// pexEnabled reports whether we exchange peers on this connection.
+func (c *Client) pexEnabled() bool {
+	return c.Pool != nil && c.Pool.PEX
+}
+
+// handlePex feeds the peers added in a ut_pex message into the pool.
+func (c *Client) handlePex(payload []byte) error {
+	if !c.pexEnabled() {
+		return nil
+	}
+
+	now := time.Now()
+	if !c.pexReceived.IsZero() && now.Sub(c.pexReceived) < pexMinInterval {
+		fmt.Printf("peer %s: ignoring pex sent too soon\n", c.Address)
+		return nil
+	}
+	c.pexReceived = now
+
+	var msg pexMessage
+	_, err := decodeExtendedPayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+
+	added, err := peers.Unmarshal(msg.Added)
+	if err != nil {
+		return err
+	}
+	added6, err := peers.Unmarshal6(msg.Added6)
+	if err != nil {
+		return err
+	}
+
+	list := append(added, added6...)
+	if len(list) > maxPexPeers {
+		list = list[:maxPexPeers]
+	}
+
+	count := c.Pool.Add(list)
+	fmt.Printf("peer %s: pex %d peers, %d new\n", c.Address, len(list), count)
+	return nil
+}
+
+// runPex sends the peer the changes to our connected peers every pexInterval
+// until the connection ends.
+func (c *Client) runPex() {
+	ticker := time.NewTicker(pexInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			err := c.sendPex()
+			if err != nil {
+				fmt.Printf("peer %s: pex failed: %v\n", c.Address, err)
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) sendPex() error {
+	current := make(map[string]peers.Peer)
+	for _, peer := range c.Pool.ConnectedPeers() {
+		if peer.String() != c.Address {
+			current[peer.String()] = peer
+		}
+	}
+
+	var added, dropped []peers.Peer
+	for addr, peer := range current {
+		if _, ok := c.pexSent[addr]; !ok && len(added) < maxPexPeers {
+			added = append(added, peer)
+		}
+	}
+	for addr, peer := range c.pexSent {
+		if _, ok := current[addr]; !ok && len(dropped) < maxPexPeers {
+			dropped = append(dropped, peer)
+		}
+	}
+
+	if len(added) == 0 && len(dropped) == 0 {
+		return nil
+	}
+
+	var msg pexMessage
+	msg.Added, msg.Added6 = peers.Compact(added)
+	msg.Dropped, msg.Dropped6 = peers.Compact(dropped)
+	for _, peer := range added {
+		if peer.IP.To4() != nil {
+			msg.AddedF = append(msg.AddedF, pexReachable)
+		} else {
+			msg.Added6F = append(msg.Added6F, pexReachable)
+		}
+	}
+
+	err := c.SendExtended("ut_pex", msg, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range added {
+		c.pexSent[peer.String()] = peer
+	}
+	for _, peer := range dropped {
+		delete(c.pexSent, peer.String())
+	}
+	return nil
+}
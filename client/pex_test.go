@@ -0,0 +1,187 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+// testPeers returns count peers with distinct addresses in 10.0.0.0/8.
+func testPeers(count int) []peers.Peer {
+	res := make([]peers.Peer, count)
+	for i := range res {
+		res[i] = peers.Peer{IP: net.IPv4(10, 0, byte(i/250), byte(i%250+1)), Port: 6881}
+	}
+	return res
+}
+
+// recordingPool returns a pool whose dials are recorded instead of made.
+func recordingPool() (*PeerPool, func() []peers.Peer) {
+	var mu sync.Mutex
+	var dialed []peers.Peer
+	pool := NewPeerPool(func(peer peers.Peer) {
+		mu.Lock()
+		dialed = append(dialed, peer)
+		mu.Unlock()
+	})
+	return pool, func() []peers.Peer {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]peers.Peer(nil), dialed...)
+	}
+}
+
+func pexPayload(t *testing.T, msg pexMessage) []byte {
+	t.Helper()
+
+	message, err := newExtendedMessage(DefaultExtensions.ids["ut_pex"], msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message.Payload
+}
+
+func TestHandlePex(t *testing.T) {
+	pool, dialed := recordingPool()
+	pool.MaxConnections = 1000
+	c := New([20]byte{}, "10.9.9.9:6881", [20]byte{}, nil, 0, nil, nil)
+	c.Pool = pool
+
+	var msg pexMessage
+	msg.Added, msg.Added6 = peers.Compact(append(testPeers(60), peers.Peer{IP: net.ParseIP("2001:db8::1"), Port: 6881}))
+	err := c.handlePex(pexPayload(t, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the peers past the first 50 of a message are ignored
+	if got := len(dialed()); got != maxPexPeers {
+		t.Errorf("dialed %d peers, want %d", got, maxPexPeers)
+	}
+
+	// a message sooner than 45s after the last one is ignored
+	msg.Added, msg.Added6 = peers.Compact(testPeers(70)[60:])
+	err = c.handlePex(pexPayload(t, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(dialed()); got != maxPexPeers {
+		t.Errorf("dialed %d peers after a message sent too soon, want %d", got, maxPexPeers)
+	}
+
+	c.pexReceived = time.Now().Add(-pexMinInterval)
+	err = c.handlePex(pexPayload(t, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(dialed()); got != maxPexPeers+10 {
+		t.Errorf("dialed %d peers, want %d", got, maxPexPeers+10)
+	}
+
+	// nothing is taken from a pool with PEX off
+	pool.PEX = false
+	c.pexReceived = time.Time{}
+	msg.Added, msg.Added6 = peers.Compact(testPeers(80)[70:])
+	err = c.handlePex(pexPayload(t, msg))
+	if err != nil || len(dialed()) != maxPexPeers+10 {
+		t.Errorf("pex with PEX off: dialed %d peers, err %v", len(dialed()), err)
+	}
+
+	// malformed compact peers are an error
+	pool.PEX = true
+	err = c.handlePex(pexPayload(t, pexMessage{Added: []byte{1, 2, 3}}))
+	if err == nil {
+		t.Error("pex with a 3 byte added list succeeded")
+	}
+}
+
+func TestSendPex(t *testing.T) {
+	pool, _ := recordingPool()
+	pool.MaxConnections = 1000
+	all := testPeers(70)
+	pool.Add(all)
+	for _, peer := range all {
+		pool.Connected(peer.String())
+	}
+
+	ours, theirs := net.Pipe()
+	defer ours.Close()
+	defer theirs.Close()
+	theirs.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the peer we talk to is one of the connected peers, and is not sent to
+	// itself
+	c := New([20]byte{}, all[0].String(), [20]byte{}, nil, 0, nil, nil)
+	c.Conn = ours
+	c.Pool = pool
+	c.PeerExtensions = &ExtendedHandshake{M: map[string]int64{"ut_pex": 7}}
+
+	exchange := func() pexMessage {
+		t.Helper()
+
+		errs := make(chan error, 1)
+		go func() { errs <- c.sendPex() }()
+		message, err := Read(theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if message.ID != MsgExtended || message.Payload[0] != 7 {
+			t.Fatalf("got message %d for extension %d, want ut_pex", message.ID, message.Payload[0])
+		}
+
+		var msg pexMessage
+		_, err = decodeExtendedPayload(message.Payload, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	sent := make(map[string]bool)
+	msg := exchange()
+	added, err := peers.Unmarshal(msg.Added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != maxPexPeers || len(msg.AddedF) != maxPexPeers || len(msg.Dropped) != 0 {
+		t.Fatalf("got %d added with %d flags and %d dropped bytes, want %d added", len(added), len(msg.AddedF), len(msg.Dropped), maxPexPeers)
+	}
+	for _, peer := range added {
+		sent[peer.String()] = true
+	}
+
+	// the rest follow in the next message, along with a peer that left if it
+	// was sent before
+	left := all[1].String()
+	wantDropped := 0
+	if sent[left] {
+		wantDropped = 1
+	}
+	pool.Done(left)
+
+	msg = exchange()
+	added, err = peers.Unmarshal(msg.Added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range added {
+		sent[peer.String()] = true
+	}
+	delete(sent, left)
+	if len(sent) != len(all)-2 || sent[all[0].String()] {
+		t.Errorf("sent %d peers, want all %d but the peer itself and the one that left", len(sent), len(all)-2)
+	}
+
+	dropped, err := peers.Unmarshal(msg.Dropped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != wantDropped || (wantDropped == 1 && dropped[0].String() != left) {
+		t.Errorf("got dropped %v, want %d of %s", dropped, wantDropped, left)
+	}
+}
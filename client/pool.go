@@ -0,0 +1,234 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+const (
+	defaultMaxConnections = 50
+
+	// defaultMaxKnown bounds how many peers a pool queues or keeps, so peers
+	// flooding us with PEX cannot grow it without limit
+	defaultMaxKnown = 2000
+
+	// a peer whose connection ended may be dialed again after retryDelay,
+	// doubled for every failed dial in a row up to maxRetryDelay
+	retryDelay    = 30 * time.Second
+	maxRetryDelay = 30 * time.Minute
+)
+
+// PeerPool collects the peers of a torrent from every source (trackers, DHT,
+// PEX) and dials them, keeping at most MaxConnections connected at a time.
+type PeerPool struct {
+	// MaxConnections bounds the peers dialed or connected at once.
+	MaxConnections int
+	// MaxKnown bounds the peers queued or connected, and separately the
+	// peers waiting to be retried.
+	MaxKnown int
+	// Dial is called for every peer to connect to. The connection must call
+	// Done with the peer's address once it ends.
+	Dial func(peer peers.Peer)
+	// PEX enables peer exchange (ut_pex) on the pool's connections. Private
+	// torrents must turn it off (BEP 27).
+	PEX bool
+
+	mu        sync.Mutex
+	pending   []peers.Peer
+	queued    map[string]bool
+	dialing   map[string]peers.Peer
+	connected map[string]peers.Peer
+	incoming  map[string]bool
+	retry     map[string]*retryEntry
+}
+
+// retryEntry is a peer whose connection ended, not to be dialed before next.
+type retryEntry struct {
+	peer     peers.Peer
+	next     time.Time
+	failures int
+}
+
+func NewPeerPool(dial func(peer peers.Peer)) *PeerPool {
+	return &PeerPool{
+		MaxConnections: defaultMaxConnections,
+		MaxKnown:       defaultMaxKnown,
+		Dial:           dial,
+		PEX:            true,
+		queued:         make(map[string]bool),
+		dialing:        make(map[string]peers.Peer),
+		connected:      make(map[string]peers.Peer),
+		incoming:       make(map[string]bool),
+		retry:          make(map[string]*retryEntry),
+	}
+}
+
+// Add queues the peers not already queued or connected and dials as many as
+// the connection limit allows. A peer whose connection ended is queued again
+// once its retry delay is over. It returns how many were queued.
+func (p *PeerPool) Add(list []peers.Peer) int {
+	p.mu.Lock()
+	added := 0
+	now := time.Now()
+	for _, peer := range list {
+		addr := peer.String()
+		if peer.Port == 0 || peer.IP == nil || peer.IP.IsUnspecified() {
+			continue
+		}
+		if p.queued[addr] || p.isLive(addr) {
+			continue
+		}
+		if entry, ok := p.retry[addr]; ok && now.Before(entry.next) {
+			continue
+		}
+		if p.size() >= p.MaxKnown {
+			break
+		}
+
+		p.queued[addr] = true
+		p.pending = append(p.pending, peer)
+		added++
+	}
+	toDial := p.next()
+	p.mu.Unlock()
+
+	for _, peer := range toDial {
+		p.Dial(peer)
+	}
+	return added
+}
+
+// Connected marks a dialed peer as connected after a successful handshake.
+func (p *PeerPool) Connected(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, ok := p.dialing[addr]
+	if !ok {
+		return
+	}
+	delete(p.dialing, addr)
+	p.connected[addr] = peer
+
+	// the peer is reachable, so its next retry waits the shortest delay
+	if entry, ok := p.retry[addr]; ok {
+		entry.failures = 0
+	}
+}
+
+// Accept takes a connection slot for a peer that connected to us, if one is
+// free. Incoming peers are not handed out through PEX, as their address is
+// not the one they listen on.
+func (p *PeerPool) Accept(addr string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.connections() >= p.MaxConnections {
+		return false
+	}
+	p.incoming[addr] = true
+	return true
+}
+
+// Done frees the connection slot of a peer and dials the next pending one.
+// A peer we dialed may be dialed again after a delay, longer after every
+// failed dial in a row.
+func (p *PeerPool) Done(addr string) {
+	p.mu.Lock()
+	if peer, ok := p.dialing[addr]; ok {
+		p.scheduleRetry(addr, peer, true)
+	} else if peer, ok := p.connected[addr]; ok {
+		p.scheduleRetry(addr, peer, false)
+	}
+	delete(p.dialing, addr)
+	delete(p.connected, addr)
+	delete(p.incoming, addr)
+	toDial := p.next()
+	p.mu.Unlock()
+
+	for _, peer := range toDial {
+		p.Dial(peer)
+	}
+}
+
+// ConnectedPeers returns the peers we currently have a connection with.
+func (p *PeerPool) ConnectedPeers() []peers.Peer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res := make([]peers.Peer, 0, len(p.connected))
+	for _, peer := range p.connected {
+		res = append(res, peer)
+	}
+	return res
+}
+
+// scheduleRetry records when a peer may be dialed again. p.mu must be held.
+func (p *PeerPool) scheduleRetry(addr string, peer peers.Peer, failed bool) {
+	entry, ok := p.retry[addr]
+	if !ok {
+		if len(p.retry) >= p.MaxKnown {
+			// forget any one peer; sources that return it add it afresh
+			for old := range p.retry {
+				delete(p.retry, old)
+				break
+			}
+		}
+		entry = &retryEntry{peer: peer}
+		p.retry[addr] = entry
+	}
+
+	delay := retryDelay
+	if failed {
+		entry.failures++
+		for i := 1; i < entry.failures && delay < maxRetryDelay; i++ {
+			delay *= 2
+		}
+	}
+	entry.next = time.Now().Add(min(delay, maxRetryDelay))
+}
+
+// next takes pending peers up to the connection limit, and then peers whose
+// retry delay is over. p.mu must be held.
+func (p *PeerPool) next() []peers.Peer {
+	var res []peers.Peer
+	for len(p.pending) > 0 && p.connections() < p.MaxConnections {
+		peer := p.pending[0]
+		p.pending = p.pending[1:]
+		delete(p.queued, peer.String())
+		p.dialing[peer.String()] = peer
+		res = append(res, peer)
+	}
+
+	now := time.Now()
+	for addr, entry := range p.retry {
+		if p.connections() >= p.MaxConnections {
+			break
+		}
+		if now.Before(entry.next) || p.queued[addr] || p.isLive(addr) {
+			continue
+		}
+		p.dialing[addr] = entry.peer
+		res = append(res, entry.peer)
+	}
+	return res
+}
+
+// isLive reports whether a peer is being dialed or connected. p.mu must be
+// held.
+func (p *PeerPool) isLive(addr string) bool {
+	_, dialing := p.dialing[addr]
+	_, connected := p.connected[addr]
+	return dialing || connected || p.incoming[addr]
+}
+
+// size counts the peers queued or live. p.mu must be held.
+func (p *PeerPool) size() int {
+	return len(p.pending) + p.connections()
+}
+
+func (p *PeerPool) connections() int {
+	return len(p.dialing) + len(p.connected) + len(p.incoming)
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeerPoolRetryBackoff(t *testing.T) {
+	pool, dialed := recordingPool()
+	peer := testPeers(1)[0]
+	addr := peer.String()
+
+	if pool.Add(testPeers(1)) != 1 || len(dialed()) != 1 {
+		t.Fatalf("new peer not dialed")
+	}
+	if pool.Add(testPeers(1)) != 0 {
+		t.Error("a peer being dialed was queued again")
+	}
+
+	// every failed dial in a row doubles the delay, up to maxRetryDelay
+	wantDelay := retryDelay
+	for failures := 1; failures <= 8; failures++ {
+		pool.Done(addr)
+		entry := pool.retry[addr]
+		if entry == nil {
+			t.Fatal("failed peer not scheduled for a retry")
+		}
+		delay := time.Until(entry.next)
+		if delay > wantDelay || delay < wantDelay-time.Second {
+			t.Errorf("after %d failures got delay %s, want %s", failures, delay.Round(time.Second), wantDelay)
+		}
+
+		if pool.Add(testPeers(1)) != 0 {
+			t.Errorf("after %d failures the peer was queued before its delay", failures)
+		}
+
+		// once the delay is over the peer is dialed again
+		entry.next = time.Now().Add(-time.Second)
+		before := len(dialed())
+		pool.Add(nil)
+		if len(dialed()) != before+1 {
+			t.Fatalf("after %d failures the peer was not dialed again", failures)
+		}
+
+		wantDelay = min(2*wantDelay, maxRetryDelay)
+	}
+
+	// a peer that connected starts over at the shortest delay
+	pool.Connected(addr)
+	pool.Done(addr)
+	delay := time.Until(pool.retry[addr].next)
+	if delay > retryDelay || delay < retryDelay-time.Second {
+		t.Errorf("after a connection got delay %s, want %s", delay.Round(time.Second), retryDelay)
+	}
+}
+
+func TestPeerPoolLimits(t *testing.T) {
+	pool, dialed := recordingPool()
+	pool.MaxConnections = 2
+	pool.MaxKnown = 5
+
+	list := testPeers(10)
+	if got := pool.Add(list); got != 5 {
+		t.Errorf("queued %d peers, want MaxKnown=5", got)
+	}
+	if got := len(dialed()); got != 2 {
+		t.Fatalf("dialed %d peers, want MaxConnections=2", got)
+	}
+
+	// a slot freed by a peer dials the next one in line
+	pool.Connected(list[0].String())
+	pool.Done(list[0].String())
+	if got := dialed(); len(got) != 3 || got[2].String() != list[2].String() {
+		t.Errorf("dialed %v, want %s next", got, list[2])
+	}
+
+	// incoming peers take slots too
+	if pool.Accept("192.0.2.1:6881") {
+		t.Error("incoming peer accepted with every slot taken")
+	}
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// maxRequestLength is the largest block we serve; peers ask for 16 KiB.
+const maxRequestLength = 128 << 10
+
+func (c *Client) sendUnchoke() error {
+	unchokeMsg := &Message{ID: MsgUnchoke}
+	_, err := c.Conn.Write(unchokeMsg.Serialize())
+	if err != nil {
+		return err
+	}
+
+	c.amChoking = false
+	fmt.Printf("peer %s: unchoked by us\n", c.Address)
+	return nil
+}
+
+// sendHaves tells the peer about every piece we verify, so it can request
+// them, until the connection ends. sent is the bitfield the peer already
+// knows about.
+func (c *Client) sendHaves(haves <-chan struct{}, sent BitField) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-haves:
+			bitfield := BitField(c.Have.Bitfield())
+			for index := 0; index < c.Have.Len(); index++ {
+				if !bitfield.HasPiece(index) || sent.HasPiece(index) {
+					continue
+				}
+
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint32(payload, uint32(index))
+
+				haveMsg := &Message{ID: MsgHave, Payload: payload}
+				_, err := c.Conn.Write(haveMsg.Serialize())
+				if err != nil {
+					return
+				}
+			}
+			sent = bitfield
+		}
+	}
+}
+
+// serveRequest answers a MsgRequest with the block, if we are not choking the
+// peer and have verified the piece.
+func (c *Client) serveRequest(payload []byte) error {
+	if len(payload) != 12 {
+		return fmt.Errorf("malformed request of %d bytes", len(payload))
+	}
+	if c.amChoking || c.Have == nil || c.Storage == nil {
+		// requests while choked are dropped
+		return nil
+	}
+
+	index := int(binary.BigEndian.Uint32(payload[0:4]))
+	begin := int(binary.BigEndian.Uint32(payload[4:8]))
+	length := int(binary.BigEndian.Uint32(payload[8:12]))
+
+	if !c.Have.Has(index) {
+		return fmt.Errorf("piece #%d is not available", index)
+	}
+	if length == 0 || length > maxRequestLength || begin+length > c.pieceSize(index) {
+		return fmt.Errorf("invalid block begin=%d length=%d of piece #%d", begin, length, index)
+	}
+
+	piecePayload := make([]byte, 8+length)
+	binary.BigEndian.PutUint32(piecePayload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(piecePayload[4:8], uint32(begin))
+	_, err := c.Storage.ReadAt(piecePayload[8:], index, begin)
+	if err != nil {
+		return err
+	}
+
+	pieceMsg := &Message{ID: MsgPiece, Payload: piecePayload}
+	_, err = c.Conn.Write(pieceMsg.Serialize())
+	if err != nil {
+		return err
+	}
+
+	if c.Stats != nil {
+		c.Stats.Uploaded.Add(int64(length))
+	}
+	return nil
+}
+
+// pieceSize returns the length of piece index; the last piece holds what is
+// left of TotalLength and is usually shorter.
+func (c *Client) pieceSize(index int) int {
+	begin := index * c.PieceLength
+	end := begin + c.PieceLength
+	if end > c.TotalLength {
+		end = c.TotalLength
+	}
+	return max(end-begin, 0)
+}
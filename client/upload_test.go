@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"bitTorrentClient/torrent"
+)
+
+// memStorage keeps the torrent's data in memory.
+type memStorage struct {
+	data        []byte
+	pieceLength int
+}
+
+func (s *memStorage) ReadAt(p []byte, piece, begin int) (int, error) {
+	return copy(p, s.data[piece*s.pieceLength+begin:]), nil
+}
+
+func (s *memStorage) WriteAt(p []byte, piece, begin int) (int, error) {
+	return copy(s.data[piece*s.pieceLength+begin:], p), nil
+}
+
+func (s *memStorage) MarkComplete(piece int) error { return nil }
+func (s *memStorage) Close() error                 { return nil }
+
+// acceptPipe runs c on one end of a pipe, as a connection the peer opened,
+// and returns the peer's end once our handshake was read from it.
+func acceptPipe(t *testing.T, c *Client) net.Conn {
+	t.Helper()
+
+	ours, theirs := net.Pipe()
+	theirs.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// closing the peer's end ends Accept, which is waited for
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.wg = &wg
+	go c.Accept(ours, &Handshake{Pstr: protocolString, InfoHash: c.InfoHash})
+	t.Cleanup(wg.Wait)
+	t.Cleanup(func() { theirs.Close() })
+
+	_, err := ReadHandshake(theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return theirs
+}
+
+func readMessage(t *testing.T, conn net.Conn, id messageID) *Message {
+	t.Helper()
+
+	for {
+		msg, err := Read(conn)
+		if err != nil {
+			t.Fatalf("reading message %d: %v", id, err)
+		}
+		if msg == nil {
+			continue
+		}
+		if msg.ID != id {
+			t.Fatalf("got message %d, want %d", msg.ID, id)
+		}
+		return msg
+	}
+}
+
+func writeMessage(t *testing.T, conn net.Conn, msg *Message) {
+	t.Helper()
+
+	_, err := conn.Write(msg.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func requestMessage(index, begin, length int) *Message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+	return &Message{ID: MsgRequest, Payload: payload}
+}
+
+func TestServeRequests(t *testing.T) {
+	const pieceLength = 32
+	data := make([]byte, 100) // 4 pieces, the last one 4 bytes long
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	have := torrent.NewProgress(4)
+	have.Set(0)
+	have.Set(3)
+
+	c := New([20]byte{1}, "pipe", [20]byte{2}, &memStorage{data: data, pieceLength: pieceLength}, pieceLength, torrent.NewWorkQueue(nil), nil)
+	c.TotalLength = len(data)
+	c.Have = have
+	conn := acceptPipe(t, c)
+
+	bitfield := readMessage(t, conn, MsgBitfield)
+	if !bytes.Equal(bitfield.Payload, []byte{0b10010000}) {
+		t.Errorf("got bitfield %08b, want 10010000", bitfield.Payload)
+	}
+	readMessage(t, conn, MsgInterested)
+
+	writeMessage(t, conn, &Message{ID: MsgInterested})
+	readMessage(t, conn, MsgUnchoke)
+
+	// the invalid requests are dropped, so only the valid ones get a reply
+	requests := []struct {
+		index, begin, length int
+		valid                bool
+	}{
+		{3, 0, 4, true},
+		{3, 0, 5, false},
+		{3, 2, 4, false},
+		{1, 0, 4, false},
+		{0, 16, 16, true},
+		{0, 16, 17, false},
+		{4, 0, 4, false},
+		{0, 0, 0, false},
+		{3, 1, 3, true},
+	}
+	// a pipe has no buffer, so the requests are written while the replies
+	// are read
+	go func() {
+		for _, req := range requests {
+			_, err := conn.Write(requestMessage(req.index, req.begin, req.length).Serialize())
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for _, req := range requests {
+		if !req.valid {
+			continue
+		}
+		piece := readMessage(t, conn, MsgPiece)
+		index := int(binary.BigEndian.Uint32(piece.Payload[0:4]))
+		begin := int(binary.BigEndian.Uint32(piece.Payload[4:8]))
+		offset := req.index*pieceLength + req.begin
+		want := data[offset : offset+req.length]
+		if index != req.index || begin != req.begin || !bytes.Equal(piece.Payload[8:], want) {
+			t.Errorf("got block index=%d begin=%d %v, want index=%d begin=%d %v", index, begin, piece.Payload[8:], req.index, req.begin, want)
+		}
+	}
+
+	// pieces verified while connected are announced, even when several are
+	// verified before the peer is told about the first
+	have.Set(2)
+	have.Set(1)
+	var haves []int
+	for len(haves) < 2 {
+		msg := readMessage(t, conn, MsgHave)
+		haves = append(haves, int(binary.BigEndian.Uint32(msg.Payload)))
+	}
+	if haves[0]+haves[1] != 3 || haves[0] == haves[1] {
+		t.Errorf("got haves %v, want 1 and 2", haves)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"bitTorrentClient/torrentFile"
+)
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func runCreate(args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	output := flags.String("o", "", "output .torrent path (default <name>.torrent)")
+	pieceLength := flags.Int64("piece-length", 0, "piece length in bytes, a power of two (default chosen from the size)")
+	comment := flags.String("comment", "", "comment stored in the torrent")
+	createdBy := flags.String("created-by", "bitTorrentClient", "created by field")
+	private := flags.Bool("private", false, "set the private flag")
+	noDate := flags.Bool("no-date", false, "leave out the creation date")
+	workers := flags.Int("workers", 0, "pieces hashed in parallel (default one per CPU)")
+	var trackers, webSeeds stringList
+	flags.Var(&trackers, "t", "tracker tier, comma separated URLs; repeat for more tiers")
+	flags.Var(&webSeeds, "w", "web seed URL; may be repeated")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: create [flags] <file-or-directory>")
+	}
+
+	opts := torrentFile.CreateOptions{
+		PieceLength:    *pieceLength,
+		Comment:        *comment,
+		CreatedBy:      *createdBy,
+		NoCreationDate: *noDate,
+		Private:        *private,
+		WebSeeds:       webSeeds,
+		Workers:        *workers,
+	}
+	for _, tier := range trackers {
+		opts.AnnounceList = append(opts.AnnounceList, strings.Split(tier, ","))
+	}
+
+	tf, err := torrentFile.Create(flags.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+
+	path := *output
+	if path == "" {
+		path = filepath.Base(tf.Info.Name) + ".torrent"
+	}
+
+	err = tf.Save(path)
+	if err != nil {
+		return err
+	}
+
+	hash, err := tf.GetInfoHash()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %s pieces=%d piece_len=%d size=%d\n", path, len(tf.GetPieceHashes()), tf.Info.PieceLength, tf.CalculateSize())
+	fmt.Println("infohash:", hex.EncodeToString(hash))
+	return nil
+}
@@ -0,0 +1,533 @@
+// Package dht implements a node of the mainline DHT (BEP 5), used to find
+// peers for a torrent without a tracker.
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"bitTorrentClient/bencode"
+	"bitTorrentClient/peers"
+)
+
+const (
+	// alpha is the number of queries a lookup keeps in flight
+	alpha = 3
+
+	defaultQueryTimeout = 5 * time.Second
+
+	// refreshInterval is how often Run pings questionable nodes
+	refreshInterval = 5 * time.Minute
+
+	maxPacketSize = 2048
+)
+
+// DefaultBootstrapNodes are well-known routers used to join the DHT.
+var DefaultBootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+// Node is a DHT node on one UDP socket.
+type Node struct {
+	// BootstrapNodes are the host:port addresses Bootstrap starts from.
+	BootstrapNodes []string
+	// QueryTimeout is how long to wait for the answer to one query.
+	QueryTimeout time.Duration
+
+	id     [20]byte
+	conn   net.PacketConn
+	table  *table
+	tokens tokens
+	store  *peerStore
+
+	mu           sync.Mutex
+	transactions map[string]transaction
+	nextTID      uint16
+}
+
+// transaction is a query waiting for its response, which must come from the
+// node the query was sent to.
+type transaction struct {
+	addr *net.UDPAddr
+	ch   chan *message
+}
+
+// New returns a node with a random id on conn. It does not answer anything
+// until Serve is running.
+func New(conn net.PacketConn) (*Node, error) {
+	var id [20]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Node{
+		BootstrapNodes: DefaultBootstrapNodes,
+		QueryTimeout:   defaultQueryTimeout,
+		id:             id,
+		conn:           conn,
+		table:          newTable(id),
+		store:          newPeerStore(),
+		transactions:   make(map[string]transaction),
+	}, nil
+}
+
+// Listen opens a UDP socket on addr and returns a node on it.
+func Listen(addr string) (*Node, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *Node) ID() [20]byte {
+	return n.id
+}
+
+func (n *Node) Addr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// Len returns the number of nodes in the routing table.
+func (n *Node) Len() int {
+	return n.table.len()
+}
+
+func (n *Node) Close() error {
+	return n.conn.Close()
+}
+
+// Serve reads packets until the socket is closed, answering queries and
+// handing responses to the queries waiting for them.
+func (n *Node) Serve() error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, addr, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		var msg message
+		err = bencode.Unmarshal(buf[:size], &msg)
+		if err != nil {
+			continue
+		}
+
+		switch msg.Y {
+		case "q":
+			n.handleQuery(&msg, udpAddr)
+		case "r", "e":
+			// a response with the id of a query to another node, such
+			// as a guessed one, is dropped
+			n.mu.Lock()
+			t, ok := n.transactions[msg.T]
+			ok = ok && t.addr.IP.Equal(udpAddr.IP) && t.addr.Port == udpAddr.Port
+			if ok {
+				delete(n.transactions, msg.T)
+			}
+			n.mu.Unlock()
+
+			if ok {
+				t.ch <- &msg
+			}
+		}
+	}
+}
+
+// Run keeps the routing table fresh until ctx is cancelled by pinging the
+// nodes that have not been heard from in a while.
+func (n *Node) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, c := range n.table.questionable(now) {
+				go n.ping(ctx, c.addr)
+			}
+		}
+	}
+}
+
+// Bootstrap joins the DHT by looking up our own id, starting from the
+// BootstrapNodes and whatever is already in the routing table.
+func (n *Node) Bootstrap(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, hostPort := range n.BootstrapNodes {
+		addr, err := net.ResolveUDPAddr("udp", hostPort)
+		if err != nil {
+			fmt.Printf("dht: bootstrap node %s: %v\n", hostPort, err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.findNode(ctx, addr, n.id)
+		}()
+	}
+	wg.Wait()
+
+	if n.table.len() == 0 {
+		return fmt.Errorf("no bootstrap node answered")
+	}
+
+	n.lookup(ctx, n.id, "find_node")
+	return nil
+}
+
+// AddNode pings a node given as host:port, such as one from a torrent's nodes
+// key, and adds it to the routing table if it answers.
+func (n *Node) AddNode(ctx context.Context, hostPort string) error {
+	addr, err := net.ResolveUDPAddr("udp", hostPort)
+	if err != nil {
+		return err
+	}
+
+	return n.ping(ctx, addr)
+}
+
+// GetPeers looks up the peers of a torrent.
+func (n *Node) GetPeers(ctx context.Context, infoHash [20]byte) ([]peers.Peer, error) {
+	res := n.lookup(ctx, infoHash, "get_peers")
+	if len(res.responded) == 0 {
+		return nil, fmt.Errorf("no dht node answered")
+	}
+	return res.peers, nil
+}
+
+// Announce looks up the peers of a torrent and tells the closest nodes that
+// we are downloading it on port. A port of 0 announces the port of our DHT
+// socket (implied_port).
+func (n *Node) Announce(ctx context.Context, infoHash [20]byte, port uint16) ([]peers.Peer, error) {
+	res := n.lookup(ctx, infoHash, "get_peers")
+	if len(res.responded) == 0 {
+		return nil, fmt.Errorf("no dht node answered")
+	}
+
+	args := queryArgs{InfoHash: infoHash[:], Port: int64(port)}
+	if port == 0 {
+		args.ImpliedPort = 1
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range res.responded {
+		token, ok := res.tokens[c.addr.String()]
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(c contact, args queryArgs) {
+			defer wg.Done()
+			args.Token = token
+			n.query(ctx, c.addr, "announce_peer", args)
+		}(c, args)
+	}
+	wg.Wait()
+
+	return res.peers, nil
+}
+
+func (n *Node) ping(ctx context.Context, addr *net.UDPAddr) error {
+	_, err := n.query(ctx, addr, "ping", queryArgs{})
+	return err
+}
+
+func (n *Node) findNode(ctx context.Context, addr *net.UDPAddr, target [20]byte) ([]contact, error) {
+	resp, err := n.query(ctx, addr, "find_node", queryArgs{Target: target[:]})
+	if err != nil {
+		return nil, err
+	}
+	return responseNodes(resp), nil
+}
+
+// query sends a query and waits for its response. A node that answers is
+// added to the routing table; one that does not is marked as failing.
+func (n *Node) query(ctx context.Context, addr *net.UDPAddr, method string, args queryArgs) (*response, error) {
+	args.ID = n.id[:]
+
+	n.mu.Lock()
+	n.nextTID++
+	tid := string(binary.BigEndian.AppendUint16(nil, n.nextTID))
+	ch := make(chan *message, 1)
+	n.transactions[tid] = transaction{addr: addr, ch: ch}
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		delete(n.transactions, tid)
+		n.mu.Unlock()
+	}()
+
+	err := n.send(addr, &message{T: tid, Y: "q", Q: method, A: &args})
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(n.QueryTimeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-ch:
+		if msg.Y == "e" {
+			return nil, parseKRPCError(msg.E)
+		}
+		if msg.R == nil || len(msg.R.ID) != 20 {
+			return nil, fmt.Errorf("dht node %s sent an invalid response", addr)
+		}
+		n.table.insert([20]byte(msg.R.ID), addr, time.Now())
+		return msg.R, nil
+
+	case <-timer.C:
+		n.table.fail(addr)
+		return nil, fmt.Errorf("dht node %s did not respond", addr)
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (n *Node) send(addr *net.UDPAddr, msg *message) error {
+	packet, err := bencode.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.conn.WriteTo(packet, addr)
+	return err
+}
+
+func (n *Node) handleQuery(msg *message, addr *net.UDPAddr) {
+	if msg.A == nil || len(msg.A.ID) != 20 {
+		n.sendError(addr, msg.T, errProtocol, "invalid arguments")
+		return
+	}
+
+	resp := &response{ID: n.id[:]}
+	ipv6 := addr.IP.To4() == nil
+
+	switch msg.Q {
+	case "ping":
+
+	case "find_node":
+		if len(msg.A.Target) != 20 {
+			n.sendError(addr, msg.T, errProtocol, "invalid target")
+			return
+		}
+		n.addClosest(resp, [20]byte(msg.A.Target), ipv6)
+
+	case "get_peers":
+		if len(msg.A.InfoHash) != 20 {
+			n.sendError(addr, msg.T, errProtocol, "invalid info_hash")
+			return
+		}
+		infoHash := [20]byte(msg.A.InfoHash)
+
+		resp.Token = n.tokens.token(addr.IP)
+		resp.Values = n.store.get(infoHash, ipv6)
+		if len(resp.Values) == 0 {
+			n.addClosest(resp, infoHash, ipv6)
+		}
+
+	case "announce_peer":
+		if len(msg.A.InfoHash) != 20 {
+			n.sendError(addr, msg.T, errProtocol, "invalid info_hash")
+			return
+		}
+		if !n.tokens.valid(msg.A.Token, addr.IP) {
+			n.sendError(addr, msg.T, errProtocol, "bad token")
+			return
+		}
+
+		port := msg.A.Port
+		if msg.A.ImpliedPort != 0 {
+			port = int64(addr.Port)
+		}
+		if port <= 0 || port > 65535 {
+			n.sendError(addr, msg.T, errProtocol, "invalid port")
+			return
+		}
+		n.store.add([20]byte(msg.A.InfoHash), peers.Peer{IP: addr.IP, Port: uint16(port)})
+
+	default:
+		n.sendError(addr, msg.T, errMethodUnknown, "method unknown")
+		return
+	}
+
+	// a node that queries us is alive, though we have not checked that it
+	// answers queries itself
+	n.table.insert([20]byte(msg.A.ID), addr, time.Now())
+
+	n.send(addr, &message{T: msg.T, Y: "r", R: resp})
+}
+
+// addClosest fills in the nodes of resp closest to target, in the address
+// family of the requester.
+func (n *Node) addClosest(resp *response, target [20]byte, ipv6 bool) {
+	var family []contact
+	for _, c := range n.table.closest(target, n.table.len()) {
+		if (c.addr.IP.To4() == nil) == ipv6 {
+			family = append(family, c)
+		}
+		if len(family) == K {
+			break
+		}
+	}
+
+	resp.Nodes, resp.Nodes6 = encodeNodes(family)
+}
+
+func (n *Node) sendError(addr *net.UDPAddr, tid string, code int64, text string) {
+	n.send(addr, &message{T: tid, Y: "e", E: []interface{}{code, text}})
+}
+
+func responseNodes(resp *response) []contact {
+	var res []contact
+	if list, err := decodeNodes(resp.Nodes, compactNodeSize); err == nil {
+		res = append(res, list...)
+	}
+	if list, err := decodeNodes(resp.Nodes6, compactNodeSize6); err == nil {
+		res = append(res, list...)
+	}
+	return res
+}
+
+type lookupResult struct {
+	// responded are the closest nodes that answered, closest first
+	responded []contact
+	// tokens are the write tokens by node address, for announce_peer
+	tokens map[string]string
+	peers  []peers.Peer
+}
+
+// lookup walks towards target, querying the alpha closest unqueried nodes at a
+// time until the K closest known nodes have all been queried.
+func (n *Node) lookup(ctx context.Context, target [20]byte, method string) *lookupResult {
+	res := &lookupResult{tokens: make(map[string]string)}
+
+	candidates := n.table.closest(target, K)
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		seen[c.addr.String()] = true
+	}
+	queried := make(map[string]bool)
+	answered := make(map[string]bool)
+
+	for ctx.Err() == nil {
+		sortByDistance(candidates, target)
+
+		var batch []contact
+		for i := 0; i < len(candidates) && i < K && len(batch) < alpha; i++ {
+			if !queried[candidates[i].addr.String()] {
+				batch = append(batch, candidates[i])
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		type answer struct {
+			c    contact
+			resp *response
+		}
+		answers := make(chan answer, len(batch))
+		for _, c := range batch {
+			queried[c.addr.String()] = true
+			go func(c contact) {
+				var args queryArgs
+				if method == "get_peers" {
+					args.InfoHash = target[:]
+				} else {
+					args.Target = target[:]
+				}
+				resp, err := n.query(ctx, c.addr, method, args)
+				if err != nil {
+					resp = nil
+				}
+				answers <- answer{c: c, resp: resp}
+			}(c)
+		}
+
+		var failed []string
+		for range batch {
+			a := <-answers
+			addr := a.c.addr.String()
+			if a.resp == nil {
+				failed = append(failed, addr)
+				continue
+			}
+
+			answered[addr] = true
+			if a.resp.Token != "" {
+				res.tokens[addr] = a.resp.Token
+			}
+			res.peers = peers.Merge(res.peers, decodeValues(a.resp.Values))
+
+			for _, c := range responseNodes(a.resp) {
+				if c.id == n.id || seen[c.addr.String()] {
+					continue
+				}
+				seen[c.addr.String()] = true
+				candidates = append(candidates, c)
+			}
+		}
+
+		// nodes that did not answer make room for the next closest ones
+		if len(failed) > 0 {
+			kept := candidates[:0]
+			for _, c := range candidates {
+				if !containsString(failed, c.addr.String()) {
+					kept = append(kept, c)
+				}
+			}
+			candidates = kept
+		}
+	}
+
+	sortByDistance(candidates, target)
+	for _, c := range candidates {
+		if answered[c.addr.String()] {
+			res.responded = append(res.responded, c)
+		}
+		if len(res.responded) == K {
+			break
+		}
+	}
+	return res
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"bitTorrentClient/bencode"
+	"bitTorrentClient/peers"
+)
+
+// startNetwork boots count nodes on 127.0.0.1, each bootstrapping from the
+// one started before it and the first from the last.
+func startNetwork(t *testing.T, count int) []*Node {
+	t.Helper()
+
+	nodes := make([]*Node, count)
+	for i := range nodes {
+		n, err := Listen("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.QueryTimeout = time.Second
+		go n.Serve()
+		t.Cleanup(func() { n.Close() })
+		nodes[i] = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bootstrap := func(n, from *Node) {
+		n.BootstrapNodes = []string{from.Addr().String()}
+		err := n.Bootstrap(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < count; i++ {
+		bootstrap(nodes[i], nodes[i-1])
+	}
+	bootstrap(nodes[0], nodes[count-1])
+
+	for i, n := range nodes {
+		if n.Len() == 0 {
+			t.Fatalf("node %d has an empty routing table", i)
+		}
+	}
+	return nodes
+}
+
+func TestAnnounceAndGetPeers(t *testing.T) {
+	nodes := startNetwork(t, 8)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	infoHash := [20]byte{0xab, 0xcd}
+	found, err := nodes[2].GetPeers(ctx, infoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Fatalf("got peers %v before anyone announced", found)
+	}
+
+	_, err = nodes[2].Announce(ctx, infoHash, 6881)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := peers.Peer{IP: net.IPv4(127, 0, 0, 1), Port: 6881}
+	for _, i := range []int{0, 5, 7} {
+		found, err := nodes[i].GetPeers(ctx, infoHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].String() != want.String() {
+			t.Errorf("node %d got peers %v, want [%s]", i, found, want)
+		}
+	}
+
+	// implied_port announces the port of the announcing node's socket
+	other := [20]byte{0xef}
+	_, err = nodes[3].Announce(ctx, other, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err = nodes[6].GetPeers(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].String() != nodes[3].Addr().String() {
+		t.Errorf("got peers %v, want [%s]", found, nodes[3].Addr())
+	}
+}
+
+func TestAnnounceToken(t *testing.T) {
+	nodes := startNetwork(t, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a, b := nodes[0], nodes[1]
+	addr := b.Addr().(*net.UDPAddr)
+	infoHash := [20]byte{0x12}
+
+	resp, err := a.query(ctx, addr, "get_peers", queryArgs{InfoHash: infoHash[:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" {
+		t.Fatal("get_peers response has no token")
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"missing", "", false},
+		{"forged", "12345678", false},
+		{"other ip", b.tokens.token(net.IPv4(192, 0, 2, 1)), false},
+		{"valid", resp.Token, true},
+	}
+	for _, test := range tests {
+		args := queryArgs{InfoHash: infoHash[:], Port: 6881, Token: test.token}
+		_, err := a.query(ctx, addr, "announce_peer", args)
+
+		var krpcErr *KRPCError
+		switch {
+		case test.ok && err != nil:
+			t.Errorf("%s token: announce failed: %v", test.name, err)
+		case !test.ok && (!errors.As(err, &krpcErr) || krpcErr.Code != errProtocol):
+			t.Errorf("%s token: got error %v, want a protocol error", test.name, err)
+		}
+	}
+
+	// a token stays valid for one rotation and expires after the second
+	b.tokens.mu.Lock()
+	b.tokens.rotated = time.Now().Add(-tokenRotation)
+	b.tokens.mu.Unlock()
+	_, err = a.query(ctx, addr, "announce_peer", queryArgs{InfoHash: infoHash[:], Port: 6881, Token: resp.Token})
+	if err != nil {
+		t.Errorf("token rejected after one rotation: %v", err)
+	}
+
+	b.tokens.mu.Lock()
+	b.tokens.rotated = time.Now().Add(-tokenRotation)
+	b.tokens.mu.Unlock()
+	_, err = a.query(ctx, addr, "announce_peer", queryArgs{InfoHash: infoHash[:], Port: 6881, Token: resp.Token})
+	if err == nil {
+		t.Error("token accepted after two rotations")
+	}
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestResponseFromOtherAddress(t *testing.T) {
+	n, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.QueryTimeout = 2 * time.Second
+	go n.Serve()
+	t.Cleanup(func() { n.Close() })
+
+	queried, other := listenUDP(t), listenUDP(t)
+
+	done := make(chan *response, 1)
+	go func() {
+		resp, _ := n.query(context.Background(), queried.LocalAddr().(*net.UDPAddr), "ping", queryArgs{})
+		done <- resp
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	queried.SetReadDeadline(time.Now().Add(2 * time.Second))
+	size, _, err := queried.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var query message
+	err = bencode.Unmarshal(buf[:size], &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := func(conn net.PacketConn, id byte) {
+		data, err := bencode.Marshal(&message{T: query.T, Y: "r", R: &response{ID: []byte{id, 19: 0}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.WriteTo(data, n.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a response with the right transaction id from another address is
+	// ignored, and the real one still gets through
+	reply(other, 1)
+	time.Sleep(50 * time.Millisecond)
+	reply(queried, 2)
+
+	resp := <-done
+	if resp == nil || resp.ID[0] != 2 {
+		t.Fatalf("got response %+v, want the one from the queried node", resp)
+	}
+}
@@ -0,0 +1,147 @@
+package dht
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"bitTorrentClient/peers"
+)
+
+// KRPC error codes
+const (
+	errGeneric       = 201
+	errServer        = 202
+	errProtocol      = 203
+	errMethodUnknown = 204
+)
+
+// message is a KRPC message: a query ("q"), a response ("r") or an error
+// ("e").
+type message struct {
+	T string        `bencode:"t"`
+	Y string        `bencode:"y"`
+	Q string        `bencode:"q,omitempty"`
+	A *queryArgs    `bencode:"a,omitempty"`
+	R *response     `bencode:"r,omitempty"`
+	E []interface{} `bencode:"e,omitempty"`
+	V string        `bencode:"v,omitempty"`
+}
+
+type queryArgs struct {
+	ID          []byte `bencode:"id"`
+	Target      []byte `bencode:"target,omitempty"`
+	InfoHash    []byte `bencode:"info_hash,omitempty"`
+	Port        int64  `bencode:"port,omitempty"`
+	ImpliedPort int64  `bencode:"implied_port,omitempty"`
+	Token       string `bencode:"token,omitempty"`
+}
+
+type response struct {
+	ID     []byte   `bencode:"id"`
+	Nodes  []byte   `bencode:"nodes,omitempty"`
+	Nodes6 []byte   `bencode:"nodes6,omitempty"`
+	Token  string   `bencode:"token,omitempty"`
+	Values [][]byte `bencode:"values,omitempty"`
+}
+
+// KRPCError is an error message sent by a remote node.
+type KRPCError struct {
+	Code    int64
+	Message string
+}
+
+func (e *KRPCError) Error() string {
+	return fmt.Sprintf("krpc error %d: %s", e.Code, e.Message)
+}
+
+func parseKRPCError(e []interface{}) *KRPCError {
+	res := &KRPCError{Code: errGeneric}
+	if len(e) > 0 {
+		if code, ok := e[0].(int64); ok {
+			res.Code = code
+		}
+	}
+	if len(e) > 1 {
+		if msg, ok := e[1].(string); ok {
+			res.Message = msg
+		}
+	}
+	return res
+}
+
+// sizes of one compact node entry: id, address and port
+const (
+	compactNodeSize  = 26
+	compactNodeSize6 = 38
+)
+
+// contact is a node as learned from a response, before it is known to be
+// alive.
+type contact struct {
+	id   [20]byte
+	addr *net.UDPAddr
+}
+
+func encodeNodes(contacts []contact) (v4 []byte, v6 []byte) {
+	for _, c := range contacts {
+		var port [2]byte
+		binary.BigEndian.PutUint16(port[:], uint16(c.addr.Port))
+
+		if ip4 := c.addr.IP.To4(); ip4 != nil {
+			v4 = append(v4, c.id[:]...)
+			v4 = append(v4, ip4...)
+			v4 = append(v4, port[:]...)
+		} else {
+			v6 = append(v6, c.id[:]...)
+			v6 = append(v6, c.addr.IP.To16()...)
+			v6 = append(v6, port[:]...)
+		}
+	}
+	return v4, v6
+}
+
+func decodeNodes(data []byte, nodeSize int) ([]contact, error) {
+	if len(data)%nodeSize != 0 {
+		return nil, fmt.Errorf("received malformed compact nodes")
+	}
+
+	res := make([]contact, 0, len(data)/nodeSize)
+	for offset := 0; offset < len(data); offset += nodeSize {
+		entry := data[offset : offset+nodeSize]
+		ipSize := nodeSize - 22
+
+		c := contact{
+			addr: &net.UDPAddr{
+				IP:   append(net.IP(nil), entry[20:20+ipSize]...),
+				Port: int(binary.BigEndian.Uint16(entry[20+ipSize:])),
+			},
+		}
+		copy(c.id[:], entry[:20])
+
+		if c.addr.Port == 0 {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// decodeValues parses the compact peers of a get_peers response.
+func decodeValues(values [][]byte) []peers.Peer {
+	var res []peers.Peer
+	for _, value := range values {
+		var list []peers.Peer
+		var err error
+		if len(value) == 18 {
+			list, err = peers.Unmarshal6(value)
+		} else {
+			list, err = peers.Unmarshal(value)
+		}
+		if err != nil {
+			continue
+		}
+		res = append(res, list...)
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+package dht
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"bitTorrentClient/bencode"
+)
+
+// state is the bencoded form of a node saved between runs.
+type state struct {
+	ID     []byte `bencode:"id"`
+	Nodes  []byte `bencode:"nodes,omitempty"`
+	Nodes6 []byte `bencode:"nodes6,omitempty"`
+}
+
+// Save writes the node id and routing table to path.
+func (n *Node) Save(path string) error {
+	s := state{ID: n.id[:]}
+	s.Nodes, s.Nodes6 = encodeNodes(n.table.nodes())
+
+	data, err := bencode.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load restores the node id and routing table written by Save. It must be
+// called before Serve. The loaded nodes count as not seen recently, so Run
+// checks them first.
+func (n *Node) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var s state
+	err = bencode.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("error while reading dht state %s: %v", path, err)
+	}
+	if len(s.ID) != 20 {
+		return fmt.Errorf("error while reading dht state %s: invalid node id", path)
+	}
+
+	n.id = [20]byte(s.ID)
+	n.table = newTable(n.id)
+
+	contacts, err := decodeNodes(s.Nodes, compactNodeSize)
+	if err != nil {
+		return fmt.Errorf("error while reading dht state %s: %v", path, err)
+	}
+	contacts6, err := decodeNodes(s.Nodes6, compactNodeSize6)
+	if err != nil {
+		return fmt.Errorf("error while reading dht state %s: %v", path, err)
+	}
+
+	for _, c := range append(contacts, contacts6...) {
+		n.table.insert(c.id, c.addr, time.Time{})
+	}
+
+	return nil
+}
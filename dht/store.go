@@ -0,0 +1,124 @@
+package dht
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"net"
+	"sync"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+const (
+	// tokens are accepted for up to twice this long (BEP 5 asks for ten
+	// minutes)
+	tokenRotation = 5 * time.Minute
+
+	// announced peers are forgotten when they do not announce again
+	peerTTL = 30 * time.Minute
+
+	// maxValues bounds the peers returned in one get_peers response so it
+	// fits in a UDP packet
+	maxValues = 50
+)
+
+// tokens hands out the write tokens of get_peers responses: a hash of the
+// requester's IP with a secret that changes every few minutes.
+type tokens struct {
+	mu       sync.Mutex
+	secret   [16]byte
+	previous [16]byte
+	rotated  time.Time
+}
+
+func (t *tokens) rotate(now time.Time) {
+	if now.Sub(t.rotated) < tokenRotation {
+		return
+	}
+	t.previous = t.secret
+	rand.Read(t.secret[:])
+	t.rotated = now
+}
+
+func (t *tokens) token(ip net.IP) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate(time.Now())
+	return makeToken(t.secret[:], ip)
+}
+
+func (t *tokens) valid(token string, ip net.IP) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate(time.Now())
+	return hmac.Equal([]byte(token), []byte(makeToken(t.secret[:], ip))) ||
+		hmac.Equal([]byte(token), []byte(makeToken(t.previous[:], ip)))
+}
+
+func makeToken(secret []byte, ip net.IP) string {
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(ip.To16())
+	return string(mac.Sum(nil)[:8])
+}
+
+// peerStore keeps the peers announced to us for each info-hash.
+type peerStore struct {
+	mu    sync.Mutex
+	peers map[[20]byte]map[string]storedPeer
+}
+
+type storedPeer struct {
+	peer  peers.Peer
+	added time.Time
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{peers: make(map[[20]byte]map[string]storedPeer)}
+}
+
+func (s *peerStore) add(infoHash [20]byte, peer peers.Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	swarm := s.peers[infoHash]
+	if swarm == nil {
+		swarm = make(map[string]storedPeer)
+		s.peers[infoHash] = swarm
+	}
+	swarm[peer.String()] = storedPeer{peer: peer, added: time.Now()}
+}
+
+// get returns the compact values for infoHash of the requester's address
+// family.
+func (s *peerStore) get(infoHash [20]byte, ipv6 bool) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var res [][]byte
+	for addr, stored := range s.peers[infoHash] {
+		if now.Sub(stored.added) > peerTTL {
+			delete(s.peers[infoHash], addr)
+			continue
+		}
+		if len(res) >= maxValues {
+			continue
+		}
+
+		v4, v6 := peers.Compact([]peers.Peer{stored.peer})
+		if ipv6 && v6 != nil {
+			res = append(res, v6)
+		} else if !ipv6 && v4 != nil {
+			res = append(res, v4)
+		}
+	}
+
+	if len(s.peers[infoHash]) == 0 {
+		delete(s.peers, infoHash)
+	}
+	return res
+}
@@ -0,0 +1,191 @@
+package dht
+
+import (
+	"bytes"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// K is the bucket size and the number of nodes a lookup converges on.
+	K = 8
+
+	// a node that failed this many queries in a row may be replaced
+	maxFailures = 2
+
+	// a node not heard from for this long is questionable (BEP 5)
+	questionableAfter = 15 * time.Minute
+)
+
+type node struct {
+	id       [20]byte
+	addr     *net.UDPAddr
+	lastSeen time.Time
+	failures int
+}
+
+func (n *node) bad() bool {
+	return n.failures >= maxFailures
+}
+
+func (n *node) contact() contact {
+	return contact{id: n.id, addr: n.addr}
+}
+
+// table is the routing table: one bucket of up to K nodes for every length of
+// the prefix shared with our own id.
+type table struct {
+	self [20]byte
+
+	mu      sync.Mutex
+	buckets [160][]*node
+}
+
+func newTable(self [20]byte) *table {
+	return &table{self: self}
+}
+
+// bucketIndex is the number of leading bits id shares with our id, or -1 for
+// our own id.
+func (t *table) bucketIndex(id [20]byte) int {
+	for i := range id {
+		x := id[i] ^ t.self[i]
+		if x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return -1
+}
+
+// insert adds a node that answered, or refreshes it. When its bucket is full,
+// the node replaces a bad one or is dropped.
+func (t *table) insert(id [20]byte, addr *net.UDPAddr, seen time.Time) {
+	index := t.bucketIndex(id)
+	if index < 0 || addr.Port == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.buckets[index]
+	for i, n := range bucket {
+		if n.id == id {
+			n.addr = addr
+			n.failures = 0
+			if seen.After(n.lastSeen) {
+				n.lastSeen = seen
+			}
+			// keep the bucket ordered from least to most recently seen
+			t.buckets[index] = append(append(bucket[:i:i], bucket[i+1:]...), n)
+			return
+		}
+	}
+
+	entry := &node{id: id, addr: addr, lastSeen: seen}
+	if len(bucket) < K {
+		t.buckets[index] = append(bucket, entry)
+		return
+	}
+
+	for i, n := range bucket {
+		if n.bad() {
+			t.buckets[index] = append(append(bucket[:i:i], bucket[i+1:]...), entry)
+			return
+		}
+	}
+}
+
+// fail records a query to addr that went unanswered.
+func (t *table) fail(addr *net.UDPAddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, bucket := range t.buckets {
+		for _, n := range bucket {
+			if n.addr.IP.Equal(addr.IP) && n.addr.Port == addr.Port {
+				n.failures++
+			}
+		}
+	}
+}
+
+// closest returns up to count good nodes sorted by distance to target.
+func (t *table) closest(target [20]byte, count int) []contact {
+	t.mu.Lock()
+	var res []contact
+	for _, bucket := range t.buckets {
+		for _, n := range bucket {
+			if !n.bad() {
+				res = append(res, n.contact())
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	sortByDistance(res, target)
+	if len(res) > count {
+		res = res[:count]
+	}
+	return res
+}
+
+// questionable returns nodes that were not heard from recently, oldest first
+// in each bucket.
+func (t *table) questionable(now time.Time) []contact {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var res []contact
+	for _, bucket := range t.buckets {
+		for _, n := range bucket {
+			if now.Sub(n.lastSeen) > questionableAfter {
+				res = append(res, n.contact())
+			}
+		}
+	}
+	return res
+}
+
+func (t *table) nodes() []contact {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var res []contact
+	for _, bucket := range t.buckets {
+		for _, n := range bucket {
+			res = append(res, n.contact())
+		}
+	}
+	return res
+}
+
+func (t *table) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := 0
+	for _, bucket := range t.buckets {
+		count += len(bucket)
+	}
+	return count
+}
+
+func distance(a, b [20]byte) [20]byte {
+	var res [20]byte
+	for i := range a {
+		res[i] = a[i] ^ b[i]
+	}
+	return res
+}
+
+func sortByDistance(contacts []contact, target [20]byte) {
+	sort.Slice(contacts, func(i, j int) bool {
+		di := distance(contacts[i].id, target)
+		dj := distance(contacts[j].id, target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+}
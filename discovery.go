@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bitTorrentClient/dht"
+	"bitTorrentClient/peers"
+)
+
+// dhtLookupTimeout bounds the bootstrap and first peer lookup of a download.
+const dhtLookupTimeout = time.Minute
+
+// startDHT joins the DHT on port, with the routing table saved by the last
+// run and the nodes of the torrent as extra starting points.
+func startDHT(ctx context.Context, port uint16, torrentNodes []string) (*dht.Node, error) {
+	node, err := dht.Listen(fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+
+	statePath := dhtStatePath()
+	if statePath != "" {
+		err = node.Load(statePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("dht:", err)
+		}
+	}
+
+	go node.Serve()
+
+	lookupCtx, cancel := context.WithTimeout(ctx, dhtLookupTimeout)
+	defer cancel()
+
+	for _, addr := range torrentNodes {
+		err := node.AddNode(lookupCtx, addr)
+		if err != nil {
+			fmt.Printf("dht: node %s: %v\n", addr, err)
+		}
+	}
+
+	err = node.Bootstrap(lookupCtx)
+	if err != nil {
+		node.Close()
+		return nil, err
+	}
+	fmt.Printf("dht: %d nodes in routing table\n", node.Len())
+
+	go node.Run(ctx)
+	return node, nil
+}
+
+// dhtPeers announces the torrent on the DHT and returns the peers found.
+func dhtPeers(ctx context.Context, node *dht.Node, infoHash [20]byte, port uint16) ([]peers.Peer, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, dhtLookupTimeout)
+	defer cancel()
+
+	return node.Announce(lookupCtx, infoHash, port)
+}
+
+// stopDHT saves the routing table for the next run and closes the node.
+func stopDHT(node *dht.Node) {
+	statePath := dhtStatePath()
+	if statePath != "" {
+		err := node.Save(statePath)
+		if err != nil {
+			fmt.Println("dht:", err)
+		}
+	}
+	node.Close()
+}
+
+func dhtStatePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	dir = filepath.Join(dir, "bitTorrentClient")
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dht.dat")
+}
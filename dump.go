@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"bitTorrentClient/bencode"
+)
+
+// binaryPreview is how many bytes of a binary string are shown without --full.
+const binaryPreview = 32
+
+func runBencode(args []string) error {
+	if len(args) == 0 || args[0] != "dump" {
+		return fmt.Errorf("usage: bencode dump [--full] [--strict] <file>")
+	}
+
+	flags := flag.NewFlagSet("bencode dump", flag.ExitOnError)
+	full := flags.Bool("full", false, "print binary strings in full instead of a preview")
+	strict := flags.Bool("strict", false, "reject input that is not canonical bencode")
+	flags.Parse(args[1:])
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: bencode dump [--full] [--strict] <file>")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	return dumpBencode(out, file, *full, *strict)
+}
+
+// dumpBencode pretty-prints the bencoded stream read from r, one element per
+// line. Strings that are not printable text are written as hex. With strict,
+// the stream must be a single canonical value, as for a strict Decode.
+func dumpBencode(w io.Writer, r io.Reader, full, strict bool) error {
+	decoder := bencode.NewDecoder(r)
+	decoder.Strict = strict
+
+	// for every open container, whether it is a dictionary and whether the
+	// next element is a key
+	type level struct{ dict, key bool }
+	var stack []level
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		indent := strings.Repeat("  ", len(stack))
+
+		var top *level
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+
+		if tok.Kind == bencode.End {
+			closer := "]"
+			if top.dict {
+				closer = "}"
+			}
+			stack = stack[:len(stack)-1]
+			fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", len(stack)), closer)
+		} else if top != nil && top.dict && top.key {
+			fmt.Fprintf(w, "%s%s: ", indent, formatString(tok.Bytes, full))
+			top.key = false
+			continue
+		} else {
+			// dictionary values follow their key on the same line
+			if top == nil || !top.dict {
+				fmt.Fprint(w, indent)
+			}
+
+			switch tok.Kind {
+			case bencode.DictStart:
+				fmt.Fprintln(w, "{")
+				stack = append(stack, level{dict: true, key: true})
+				continue
+			case bencode.ListStart:
+				fmt.Fprintln(w, "[")
+				stack = append(stack, level{})
+				continue
+			case bencode.Int:
+				fmt.Fprintln(w, tok.Int)
+			case bencode.String:
+				fmt.Fprintln(w, formatString(tok.Bytes, full))
+			}
+		}
+
+		// a complete value was written, so the enclosing dictionary expects a key
+		if len(stack) > 0 && stack[len(stack)-1].dict {
+			stack[len(stack)-1].key = true
+		}
+	}
+}
+
+// formatString quotes printable text and hex-escapes anything else.
+func formatString(b []byte, full bool) string {
+	if isText(b) {
+		return strconv.Quote(string(b))
+	}
+
+	shown := b
+	if !full && len(shown) > binaryPreview {
+		shown = shown[:binaryPreview]
+	}
+
+	res := fmt.Sprintf("<%d bytes> %s", len(b), hex.EncodeToString(shown))
+	if len(shown) < len(b) {
+		res += "..."
+	}
+	return res
+}
+
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,276 @@
+// Package lsd implements Local Service Discovery (BEP 14): torrents are
+// announced by multicast on the local network, and peers announcing the
+// same torrents are picked up.
+package lsd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+const (
+	DefaultInterval = 5 * time.Minute
+
+	// an announce stays well below the usual MTU
+	maxAnnounceSize = 1400
+)
+
+var (
+	Group4 = &net.UDPAddr{IP: net.IPv4(239, 192, 152, 143), Port: 6771}
+	Group6 = &net.UDPAddr{IP: net.ParseIP("ff15::efc0:988f"), Port: 6771}
+)
+
+type group struct {
+	recv net.PacketConn
+	send net.PacketConn
+	addr *net.UDPAddr
+}
+
+// Service announces our torrents on the local network and reports the peers
+// that announce them too.
+type Service struct {
+	// Port is the peer port we announce.
+	Port uint16
+	// Interval is the time between announces of the same torrents.
+	Interval time.Duration
+	// OnPeer is called for every peer that announces one of our torrents.
+	OnPeer func(infoHash [20]byte, peer peers.Peer)
+
+	cookie string
+
+	mu       sync.Mutex
+	torrents map[[20]byte]bool
+	groups   []group
+}
+
+func New(port uint16, onPeer func(infoHash [20]byte, peer peers.Peer)) *Service {
+	var cookie [8]byte
+	rand.Read(cookie[:])
+
+	return &Service{
+		Port:     port,
+		Interval: DefaultInterval,
+		OnPeer:   onPeer,
+		cookie:   hex.EncodeToString(cookie[:]),
+		torrents: make(map[[20]byte]bool),
+	}
+}
+
+// Add starts announcing a torrent and accepting peers for it.
+func (s *Service) Add(infoHash [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.torrents[infoHash] = true
+}
+
+func (s *Service) Remove(infoHash [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.torrents, infoHash)
+}
+
+// Listen joins the IPv4 and IPv6 multicast groups. It fails only if neither
+// can be joined.
+func (s *Service) Listen() error {
+	var errs []error
+	for _, addr := range []*net.UDPAddr{Group4, Group6} {
+		network := "udp4"
+		if addr.IP.To4() == nil {
+			network = "udp6"
+		}
+
+		recv, err := net.ListenMulticastUDP(network, nil, addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		// the group socket has multicast loopback off, so announces go out
+		// on their own socket where it stays on and other clients on this
+		// host hear them too
+		send, err := net.ListenPacket(network, ":0")
+		if err != nil {
+			recv.Close()
+			errs = append(errs, err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.groups = append(s.groups, group{recv: recv, send: send, addr: addr})
+		s.mu.Unlock()
+	}
+
+	if len(errs) == 2 {
+		return fmt.Errorf("error while joining the lsd groups: %v", errors.Join(errs...))
+	}
+	return nil
+}
+
+// AddConn makes the service announce to addr on conn and read announces from
+// it. Listen uses it for the multicast groups; any packet conn will do.
+func (s *Service) AddConn(conn net.PacketConn, addr *net.UDPAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups = append(s.groups, group{recv: conn, send: conn, addr: addr})
+}
+
+// Run announces right away and then every Interval, handling incoming
+// announces until ctx is cancelled. It closes the conns when it returns.
+func (s *Service) Run(ctx context.Context) {
+	s.mu.Lock()
+	groups := append([]group(nil), s.groups...)
+	s.mu.Unlock()
+
+	for _, g := range groups {
+		go s.serve(g.recv)
+	}
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.Announce()
+
+		select {
+		case <-ctx.Done():
+			for _, g := range groups {
+				g.recv.Close()
+				if g.send != g.recv {
+					g.send.Close()
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Announce sends our torrents to every group.
+func (s *Service) Announce() {
+	s.mu.Lock()
+	var hashes [][20]byte
+	for hash := range s.torrents {
+		hashes = append(hashes, hash)
+	}
+	groups := append([]group(nil), s.groups...)
+	s.mu.Unlock()
+
+	for _, g := range groups {
+		for _, packet := range s.messages(g.addr, hashes) {
+			_, err := g.send.WriteTo(packet, g.addr)
+			if err != nil {
+				fmt.Printf("lsd: error while announcing to %s: %v\n", g.addr, err)
+				break
+			}
+		}
+	}
+}
+
+// messages builds the BT-SEARCH announces for hashes, as many info-hashes
+// per message as fit.
+func (s *Service) messages(addr *net.UDPAddr, hashes [][20]byte) [][]byte {
+	header := fmt.Sprintf("BT-SEARCH * HTTP/1.1\r\nHost: %s\r\nPort: %d\r\n", addr, s.Port)
+	trailer := fmt.Sprintf("cookie: %s\r\n\r\n\r\n", s.cookie)
+
+	var res [][]byte
+	var buf bytes.Buffer
+	for _, hash := range hashes {
+		line := fmt.Sprintf("Infohash: %s\r\n", hex.EncodeToString(hash[:]))
+		if buf.Len() > 0 && len(header)+buf.Len()+len(line)+len(trailer) > maxAnnounceSize {
+			res = append(res, []byte(header+buf.String()+trailer))
+			buf.Reset()
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		res = append(res, []byte(header+buf.String()+trailer))
+	}
+	return res
+}
+
+func (s *Service) serve(conn net.PacketConn) {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		port, hashes, cookie, err := parseAnnounce(buf[:n])
+		if err != nil || cookie == s.cookie {
+			// our own announce looped back, or not an announce at all
+			continue
+		}
+
+		for _, hash := range hashes {
+			s.mu.Lock()
+			ours := s.torrents[hash]
+			s.mu.Unlock()
+
+			if ours && s.OnPeer != nil {
+				s.OnPeer(hash, peers.Peer{IP: udpAddr.IP, Port: port})
+			}
+		}
+	}
+}
+
+// parseAnnounce reads a BT-SEARCH message.
+func parseAnnounce(data []byte) (uint16, [][20]byte, string, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+
+	line, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "BT-SEARCH * HTTP/1.1" {
+		return 0, nil, "", fmt.Errorf("not a BT-SEARCH message")
+	}
+
+	// the headers are HTTP style, but the message may lack the final blank
+	// line, so parse them by hand
+	header := make(http.Header)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line != "" {
+			key, value, ok := strings.Cut(line, ":")
+			if ok {
+				header.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+			}
+		}
+		if err != nil || line == "" {
+			break
+		}
+	}
+
+	port, err := strconv.ParseUint(header.Get("Port"), 10, 16)
+	if err != nil || port == 0 {
+		return 0, nil, "", fmt.Errorf("invalid port")
+	}
+
+	var hashes [][20]byte
+	for _, value := range header.Values("Infohash") {
+		decoded, err := hex.DecodeString(value)
+		if err != nil || len(decoded) != 20 {
+			continue
+		}
+		hashes = append(hashes, [20]byte(decoded))
+	}
+
+	return uint16(port), hashes, header.Get("Cookie"), nil
+}
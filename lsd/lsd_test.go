@@ -0,0 +1,140 @@
+package lsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+type found struct {
+	hash [20]byte
+	peer string
+}
+
+func listenLoopback(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// startService runs a service for hashes that announces to addr on conn
+// instead of a multicast group, and reports the peers it finds on a channel.
+func startService(t *testing.T, port uint16, conn net.PacketConn, addr net.Addr, hashes ...[20]byte) (*Service, chan found) {
+	t.Helper()
+
+	ch := make(chan found, 16)
+	s := New(port, func(hash [20]byte, peer peers.Peer) {
+		ch <- found{hash: hash, peer: peer.String()}
+	})
+	for _, hash := range hashes {
+		s.Add(hash)
+	}
+	s.AddConn(conn, addr.(*net.UDPAddr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Run(ctx)
+	return s, ch
+}
+
+func expectFound(t *testing.T, ch chan found, want found) {
+	t.Helper()
+
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Errorf("got %x from %s, want %x from %s", got.hash[0], got.peer, want.hash[0], want.peer)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no peer found, want %x from %s", want.hash[0], want.peer)
+	}
+}
+
+func expectNothing(t *testing.T, ch chan found) {
+	t.Helper()
+
+	select {
+	case got := <-ch:
+		t.Errorf("unexpected peer %s for %x", got.peer, got.hash[0])
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServicesFindEachOther(t *testing.T) {
+	shared, onlyA, onlyB := [20]byte{1}, [20]byte{2}, [20]byte{3}
+
+	connA, connB := listenLoopback(t), listenLoopback(t)
+	_, foundA := startService(t, 6881, connA, connB.LocalAddr(), shared, onlyA)
+	_, foundB := startService(t, 6882, connB, connA.LocalAddr(), shared, onlyB)
+
+	// each service hears the other's port for the torrent they share, and
+	// nothing for the torrent only the other one has
+	expectFound(t, foundA, found{hash: shared, peer: "127.0.0.1:6882"})
+	expectFound(t, foundB, found{hash: shared, peer: "127.0.0.1:6881"})
+	expectNothing(t, foundA)
+	expectNothing(t, foundB)
+}
+
+func TestServiceIgnoresOwnAndUnknown(t *testing.T) {
+	shared, unknown := [20]byte{1}, [20]byte{2}
+
+	conn, sender := listenLoopback(t), listenLoopback(t)
+	s, ch := startService(t, 6881, conn, sender.LocalAddr(), shared)
+	other := New(6882, nil)
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	packets := [][]byte{
+		// our own announce looped back, for a torrent we have
+		s.messages(addr, [][20]byte{shared})[0],
+		// another client's announce for a torrent we do not have
+		other.messages(addr, [][20]byte{unknown})[0],
+		// another client's announce for our torrent
+		other.messages(addr, [][20]byte{shared})[0],
+	}
+	for _, packet := range packets {
+		_, err := sender.WriteTo(packet, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// loopback keeps the packets in order, so only the last one may report a
+	// peer
+	expectFound(t, ch, found{hash: shared, peer: "127.0.0.1:6882"})
+	expectNothing(t, ch)
+}
+
+func TestParseAnnounce(t *testing.T) {
+	s := New(6881, nil)
+	addr := Group4
+	hashes := [][20]byte{{1}, {2}}
+
+	port, got, cookie, err := parseAnnounce(s.messages(addr, hashes)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 6881 || cookie != s.cookie || len(got) != 2 || got[0] != hashes[0] || got[1] != hashes[1] {
+		t.Errorf("got port=%d cookie=%q hashes=%x", port, cookie, got)
+	}
+
+	invalid := []string{
+		"",
+		"NOTIFY * HTTP/1.1\r\nPort: 6881\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 0\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 70000\r\n\r\n",
+	}
+	for _, data := range invalid {
+		_, _, _, err := parseAnnounce([]byte(data))
+		if err == nil {
+			t.Errorf("parseAnnounce(%q) succeeded", data)
+		}
+	}
+}
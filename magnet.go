@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"bitTorrentClient/magnet"
+	"bitTorrentClient/torrentFile"
+)
+
+func runMagnet(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: magnet <path-to-file|magnet-uri>")
+	}
+
+	if !strings.HasPrefix(args[0], "magnet:") {
+		tf, err := torrentFile.Open(args[0])
+		if err != nil {
+			return err
+		}
+
+		m, err := magnet.FromTorrentFile(tf)
+		if err != nil {
+			return err
+		}
+		fmt.Println(m)
+		return nil
+	}
+
+	m, err := magnet.Parse(args[0])
+	if err != nil {
+		return err
+	}
+
+	if m.InfoHash != [20]byte{} {
+		fmt.Println("infohash:   ", hex.EncodeToString(m.InfoHash[:]))
+	}
+	if m.InfoHashV2 != nil {
+		fmt.Println("infohash v2:", hex.EncodeToString(m.InfoHashV2))
+	}
+	if m.DisplayName != "" {
+		fmt.Println("name:       ", m.DisplayName)
+	}
+	for _, tracker := range m.Trackers {
+		fmt.Println("tracker:    ", tracker)
+	}
+	for _, seed := range m.WebSeeds {
+		fmt.Println("web seed:   ", seed)
+	}
+	for _, peer := range m.Peers {
+		fmt.Println("peer:       ", peer)
+	}
+	if len(m.SelectOnly) > 0 {
+		fmt.Println("files:      ", m.SelectOnly)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bitTorrentClient/torrentFile"
+)
+
+const (
+	btihPrefix = "urn:btih:"
+	btmhPrefix = "urn:btmh:"
+
+	// sha256Multihash is the multihash prefix of a BitTorrent v2 info-hash:
+	// function code 0x12 (sha2-256) followed by the digest length 0x20.
+	sha256Multihash = "1220"
+)
+
+// Magnet holds the fields of a magnet URI (BEP 9, BEP 53 and the v2 btmh
+// form). At least one of InfoHash and InfoHashV2 is set.
+type Magnet struct {
+	InfoHash    [20]byte
+	InfoHashV2  []byte // 32 byte SHA-256 digest, nil for v1-only links
+	DisplayName string
+	Trackers    []string
+	WebSeeds    []string
+	Peers       []string    // host:port addresses from x.pe
+	SelectOnly  []FileRange // file indices from so
+}
+
+// FileRange is an inclusive range of file indices from so, such as 4-6. A
+// single index has First == Last.
+type FileRange struct {
+	First, Last int
+}
+
+func (r FileRange) String() string {
+	if r.First == r.Last {
+		return strconv.Itoa(r.First)
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+func Parse(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing magnet uri: %v", err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("not a magnet uri: %q", uri)
+	}
+
+	params, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing magnet parameters: %v", err)
+	}
+
+	var m Magnet
+	var hasV1 bool
+
+	// BEP 9 allows numbered variants such as xt.1 and tr.2, sorting the keys
+	// keeps those in order
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+
+	for _, key := range keys {
+		name, _, _ := strings.Cut(key, ".")
+		if key == "x.pe" {
+			name = key
+		}
+
+		for _, value := range params[key] {
+			switch name {
+			case "xt":
+				v1, err := m.parseExactTopic(value)
+				if err != nil {
+					return nil, err
+				}
+				hasV1 = hasV1 || v1
+			case "dn":
+				m.DisplayName = value
+			case "tr":
+				m.Trackers = append(m.Trackers, value)
+			case "ws":
+				m.WebSeeds = append(m.WebSeeds, value)
+			case "x.pe":
+				if _, _, err := net.SplitHostPort(value); err != nil {
+					return nil, fmt.Errorf("invalid peer address %q: %v", value, err)
+				}
+				m.Peers = append(m.Peers, value)
+			case "so":
+				ranges, err := parseSelectOnly(value)
+				if err != nil {
+					return nil, err
+				}
+				m.SelectOnly = append(m.SelectOnly, ranges...)
+			}
+		}
+	}
+
+	if !hasV1 && m.InfoHashV2 == nil {
+		return nil, fmt.Errorf("magnet uri has no urn:btih or urn:btmh exact topic")
+	}
+
+	return &m, nil
+}
+
+// sortKeys sorts parameter names by name and then by the number after the
+// dot, so tr.2 comes before tr.10 and the plain tr before both.
+func sortKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		nameI, suffixI, _ := strings.Cut(keys[i], ".")
+		nameJ, suffixJ, _ := strings.Cut(keys[j], ".")
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+
+		numI, errI := strconv.Atoi(suffixI)
+		numJ, errJ := strconv.Atoi(suffixJ)
+		if suffixI == "" || suffixJ == "" || errI != nil || errJ != nil {
+			return suffixI < suffixJ
+		}
+		return numI < numJ
+	})
+}
+
+// parseExactTopic stores the info-hash from an xt value and reports whether
+// it was a v1 hash.
+func (m *Magnet) parseExactTopic(value string) (bool, error) {
+	switch {
+	case strings.HasPrefix(value, btihPrefix):
+		hash, err := decodeInfoHash(value[len(btihPrefix):])
+		if err != nil {
+			return false, err
+		}
+		m.InfoHash = hash
+		return true, nil
+
+	case strings.HasPrefix(value, btmhPrefix):
+		multihash := strings.ToLower(value[len(btmhPrefix):])
+		if !strings.HasPrefix(multihash, sha256Multihash) {
+			return false, fmt.Errorf("unsupported multihash in %q", value)
+		}
+
+		digest, err := hex.DecodeString(multihash[len(sha256Multihash):])
+		if err != nil || len(digest) != 32 {
+			return false, fmt.Errorf("invalid v2 info-hash in %q", value)
+		}
+		m.InfoHashV2 = digest
+		return false, nil
+	}
+
+	// other exact topics (ed2k, sha1 of a single file, ...) are not ours
+	return false, nil
+}
+
+// decodeInfoHash accepts the 40 character hex and 32 character base32 forms.
+func decodeInfoHash(s string) ([20]byte, error) {
+	var hash [20]byte
+
+	var decoded []byte
+	var err error
+	switch len(s) {
+	case 40:
+		decoded, err = hex.DecodeString(s)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return hash, fmt.Errorf("info-hash %q has invalid length %d", s, len(s))
+	}
+
+	if err != nil {
+		return hash, fmt.Errorf("invalid info-hash %q: %v", s, err)
+	}
+
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// parseSelectOnly reads a BEP 53 file list such as "0,2,4-6". Ranges are
+// kept as they are rather than expanded, as they can be arbitrarily large.
+func parseSelectOnly(value string) ([]FileRange, error) {
+	var res []FileRange
+
+	for _, part := range strings.Split(value, ",") {
+		if part == "" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid file index %q in so", part)
+		}
+
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid file range %q in so", part)
+			}
+		}
+
+		res = append(res, FileRange{First: start, Last: end})
+	}
+
+	return res, nil
+}
+
+// FromTorrentFile builds a magnet link carrying the info-hash, name,
+// trackers and web seeds of tf.
+func FromTorrentFile(tf *torrentFile.TorrentFile) (*Magnet, error) {
+	hash, err := tf.GetInfoHash()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Magnet{
+		DisplayName: tf.Info.Name,
+		Trackers:    tf.Trackers(),
+		WebSeeds:    tf.WebSeeds(),
+	}
+	copy(m.InfoHash[:], hash)
+
+	return m, nil
+}
+
+// String formats the magnet as a URI, with the exact topics first.
+func (m *Magnet) String() string {
+	var params []string
+
+	if m.InfoHash != [20]byte{} {
+		params = append(params, "xt="+btihPrefix+hex.EncodeToString(m.InfoHash[:]))
+	}
+	if m.InfoHashV2 != nil {
+		params = append(params, "xt="+btmhPrefix+sha256Multihash+hex.EncodeToString(m.InfoHashV2))
+	}
+	if m.DisplayName != "" {
+		params = append(params, "dn="+url.QueryEscape(m.DisplayName))
+	}
+	for _, tracker := range m.Trackers {
+		params = append(params, "tr="+url.QueryEscape(tracker))
+	}
+	for _, seed := range m.WebSeeds {
+		params = append(params, "ws="+url.QueryEscape(seed))
+	}
+	for _, peer := range m.Peers {
+		params = append(params, "x.pe="+url.QueryEscape(peer))
+	}
+	if len(m.SelectOnly) > 0 {
+		var ranges []string
+		for _, r := range m.SelectOnly {
+			ranges = append(ranges, r.String())
+		}
+		params = append(params, "so="+strings.Join(ranges, ","))
+	}
+
+	return "magnet:?" + strings.Join(params, "&")
+}
@@ -0,0 +1,126 @@
+package magnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testHash is 00 01 02 ... 13, given in hex and in base32.
+var testHash = [20]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}
+
+const (
+	testHex    = "000102030405060708090a0b0c0d0e0f10111213"
+	testBase32 = "AAAQEAYEAUDAOCAJBIFQYDIOB4IBCEQT"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		want Magnet
+	}{
+		{
+			"hex",
+			"magnet:?xt=urn:btih:" + testHex,
+			Magnet{InfoHash: testHash},
+		},
+		{
+			"base32",
+			"magnet:?xt=urn:btih:" + testBase32,
+			Magnet{InfoHash: testHash},
+		},
+		{
+			"lowercase base32",
+			"magnet:?xt=urn:btih:aaaqeayeaudaocajbifqydiob4ibceqt",
+			Magnet{InfoHash: testHash},
+		},
+		{
+			"name and trackers",
+			"magnet:?xt=urn:btih:" + testHex + "&dn=some+file.iso&tr=http%3A%2F%2Fa%2Fannounce&tr=udp%3A%2F%2Fb%3A80",
+			Magnet{InfoHash: testHash, DisplayName: "some file.iso", Trackers: []string{"http://a/announce", "udp://b:80"}},
+		},
+		{
+			"numbered trackers in order",
+			"magnet:?tr.10=t10&xt.1=urn:btih:" + testHex + "&tr.2=t2&tr=t",
+			Magnet{InfoHash: testHash, Trackers: []string{"t", "t2", "t10"}},
+		},
+		{
+			"web seeds, peers and files",
+			"magnet:?xt=urn:btih:" + testHex + "&ws=http%3A%2F%2Fs%2F&x.pe=10.0.0.1:6881&x.pe=[::1]:6881&so=0,2,4-6",
+			Magnet{
+				InfoHash:   testHash,
+				WebSeeds:   []string{"http://s/"},
+				Peers:      []string{"10.0.0.1:6881", "[::1]:6881"},
+				SelectOnly: []FileRange{{0, 0}, {2, 2}, {4, 6}},
+			},
+		},
+		{
+			"other exact topics are ignored",
+			"magnet:?xt=urn:ed2k:abc&xt=urn:btih:" + testHex,
+			Magnet{InfoHash: testHash},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.uri)
+		if err != nil {
+			t.Errorf("%s: Parse failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *got, test.want)
+		}
+	}
+}
+
+func TestParseV2(t *testing.T) {
+	digest := testHex + testHex[:24]
+	got, err := Parse("magnet:?xt=urn:btmh:1220" + digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.InfoHashV2) != 32 || got.InfoHash != [20]byte{} {
+		t.Errorf("got v1 %x and v2 %x", got.InfoHash, got.InfoHashV2)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	invalid := []string{
+		"http://example.com/?xt=urn:btih:" + testHex,
+		"magnet:?dn=name",
+		"magnet:?xt=urn:ed2k:abc",
+		"magnet:?xt=urn:btih:" + testHex[:38],
+		"magnet:?xt=urn:btih:" + testHex[:38] + "zz",
+		"magnet:?xt=urn:btih:" + testBase32[:31] + "1",
+		"magnet:?xt=urn:btmh:1120" + testHex + testHex[:24],
+		"magnet:?xt=urn:btih:" + testHex + "&x.pe=10.0.0.1",
+		"magnet:?xt=urn:btih:" + testHex + "&so=3-1",
+		"magnet:?xt=urn:btih:" + testHex + "&so=a",
+	}
+
+	for _, uri := range invalid {
+		if _, err := Parse(uri); err == nil {
+			t.Errorf("Parse(%q) succeeded", uri)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	m := &Magnet{
+		InfoHash:    testHash,
+		DisplayName: "a b&c",
+		Trackers:    []string{"http://a/announce?x=1", "udp://b:80"},
+		WebSeeds:    []string{"http://s/"},
+		Peers:       []string{"10.0.0.1:6881"},
+		SelectOnly:  []FileRange{{1, 1}, {3, 5}},
+	}
+
+	uri := m.String()
+	got, err := Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Parse(%q) = %+v, want %+v", uri, got, m)
+	}
+}
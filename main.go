@@ -1,95 +1,524 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"net"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
-	"time"
 
-	"bitTorrentClient/bencode"
 	"bitTorrentClient/client"
+	"bitTorrentClient/dht"
+	"bitTorrentClient/lsd"
+	"bitTorrentClient/magnet"
 	"bitTorrentClient/peers"
+	"bitTorrentClient/storage"
+	"bitTorrentClient/torrent"
 	"bitTorrentClient/torrentFile"
+	"bitTorrentClient/tracker"
 )
 
+const usage = `usage:
+  go run . <path-to-file|magnet-uri>       download a torrent
+  go run . download [flags] <path|uri>     download a torrent with options
+  go run . show [--json] <path-to-file>    print the metadata of a torrent
+  go run . create [flags] <path>           build a .torrent from a file or directory
+  go run . scrape <path-to-file>...        ask the trackers for swarm counters
+  go run . verify [flags] <path-to-file>   hash-check data already on disk
+  go run . tracker [flags]                 run a tracker (HTTP, optionally UDP)
+  go run . magnet <path-to-file|magnet-uri> print a magnet link, or the fields of one
+  go run . bencode dump [flags] <file>     pretty-print any bencoded file`
+
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("correct way to use this is go run . <path-to-file>")
+		fmt.Println(usage)
+		os.Exit(2)
 	}
 
-	tf, err := torrentFile.Open(os.Args[1])
+	var err error
+	switch os.Args[1] {
+	case "download":
+		err = runDownload(os.Args[2:])
+	case "show":
+		err = runShow(os.Args[2:])
+	case "create":
+		err = runCreate(os.Args[2:])
+	case "scrape":
+		err = runScrape(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "tracker":
+		err = runTracker(os.Args[2:])
+	case "magnet":
+		err = runMagnet(os.Args[2:])
+	case "bencode":
+		err = runBencode(os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Println(usage)
+	default:
+		download(os.Args[1], downloadOptions{DHT: true, LSD: true})
+	}
 
 	if err != nil {
-		fmt.Println("open_err:", err)
+		fmt.Println("error:", err)
 		os.Exit(1)
 	}
+}
+
+// unknownLeft is the left reported to trackers before the metadata of a
+// magnet link arrives, as libtorrent does.
+const unknownLeft = 16 << 10
+
+type downloadOptions struct {
+	// AllTiers announces to every tracker tier at once instead of only
+	// falling through to the next tier on failure.
+	AllTiers bool
+	// DHT looks for peers on the DHT as well as on the trackers.
+	DHT bool
+	// LSD looks for peers on the local network (BEP 14).
+	LSD bool
+	// Seed keeps serving the torrent to peers after the download, until
+	// interrupted.
+	Seed bool
+	// Only and Exclude select the files to download by glob, and Priorities
+	// set the priority of the files selected; see filePriorities.
+	Only       []string
+	Exclude    []string
+	Priorities []priorityRule
+}
+
+func runDownload(args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	allTiers := flags.Bool("all-tiers", false, "announce to all tracker tiers in parallel")
+	useDHT := flags.Bool("dht", true, "find peers on the DHT too")
+	useLSD := flags.Bool("lsd", true, "find peers on the local network too")
+	seed := flags.Bool("seed", false, "keep seeding after the download until interrupted")
+	var only, exclude stringList
+	flags.Var(&only, "only", "download only the files matching this glob; may be repeated")
+	flags.Var(&exclude, "exclude", "skip the files matching this glob; may be repeated")
+	var priorities stringList
+	flags.Var(&priorities, "priority", "download the files matching a glob first or last, as glob=high or glob=low; may be repeated")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: download [--all-tiers] [--dht=false] [--lsd=false] [--seed] [--only glob] [--exclude glob] [--priority glob=level] <path-to-file|magnet-uri>")
+	}
 
-	// fmt.Println("tf: ", tf.Info)
+	rules, err := parsePriorityRules(priorities)
+	if err != nil {
+		return err
+	}
 
-	hash, err := tf.GetInfoHash()
+	patterns := append(append([]string(nil), only...), exclude...)
+	for _, rule := range rules {
+		patterns = append(patterns, rule.pattern)
+	}
+	err = checkPatterns(patterns)
 	if err != nil {
-		fmt.Println("infohash_err:", err)
-		os.Exit(1)
+		return err
 	}
-	fmt.Printf("torrent name=%s pieces=%d piece_len=%d size=%d\n", tf.Info.Name, len(tf.GetPieceHashes()), tf.Info.PieceLength, tf.CalculateSize())
+
+	download(flags.Arg(0), downloadOptions{AllTiers: *allTiers, DHT: *useDHT, LSD: *useLSD, Seed: *seed, Only: only, Exclude: exclude, Priorities: rules})
+	return nil
+}
+
+func download(arg string, opts downloadOptions) {
+	var tf *torrentFile.TorrentFile
+	var hash []byte
+	var trackers []string
+	var linkPeers []peers.Peer
+
+	if strings.HasPrefix(arg, "magnet:") {
+		m, err := magnet.Parse(arg)
+		if err != nil {
+			fmt.Println("magnet_err:", err)
+			os.Exit(1)
+		}
+		if m.InfoHash == [20]byte{} {
+			fmt.Println("magnet_err: only magnet links with a v1 (btih) info-hash are supported")
+			os.Exit(1)
+		}
+		linkPeers = resolvePeers(m.Peers)
+		if len(m.Trackers) == 0 && len(linkPeers) == 0 && !opts.DHT {
+			fmt.Println("magnet_err: magnet link has no trackers or peers and the dht is off")
+			os.Exit(1)
+		}
+
+		// until the metadata arrives all we know is the info-hash and trackers
+		hash = m.InfoHash[:]
+		trackers = m.Trackers
+		tf = &torrentFile.TorrentFile{}
+		for _, tracker := range trackers {
+			tf.AnnounceList = append(tf.AnnounceList, []string{tracker})
+		}
+	} else {
+		var err error
+		tf, err = torrentFile.Open(arg)
+
+		if err != nil {
+			fmt.Println("open_err:", err)
+			os.Exit(1)
+		}
+
+		hash, err = tf.GetInfoHash()
+		if err != nil {
+			fmt.Println("infohash_err:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("torrent name=%s pieces=%d piece_len=%d size=%d\n", tf.Info.Name, len(tf.GetPieceHashes()), tf.Info.PieceLength, tf.CalculateSize())
+	}
+
 	fmt.Println("infohash:", hex.EncodeToString(hash))
-	trackerUrl, err := tf.BuildTrackerUrl([20]byte(hash))
 
+	peerId, err := tracker.GeneratePeerID()
 	if err != nil {
-		fmt.Println("tracker_url_err:", err)
+		fmt.Println("peer_id_err:", err)
 		os.Exit(1)
 	}
+	tf.PeerId = peerId[:]
 
-	_ = http.Client{Timeout: 15 * time.Second}
+	resumeFile := resumePath([20]byte(hash))
+	state := loadResume(resumeFile)
 
-	resp, err := http.Get(trackerUrl)
+	stats := &torrent.Stats{}
+	if state != nil {
+		stats.Uploaded.Store(state.Uploaded)
+		stats.Downloaded.Store(state.Downloaded)
+	}
+
+	// have is set once the metadata is known and the data on disk checked
+	var have *torrent.Progress
+	statsFunc := func() tracker.Stats {
+		// without the metadata the size is unknown; report a block left so
+		// trackers do not count us as a seeder
+		left := int64(unknownLeft)
+		if have != nil {
+			left = leftBytes(have, tf.Info.PieceLength, int64(tf.CalculateSize()))
+		}
+		return tracker.Stats{
+			Uploaded:   stats.Uploaded.Load(),
+			Downloaded: stats.Downloaded.Load(),
+			Left:       left,
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
 
+	var announcer *tracker.Announcer
+
+	tiers, err := tracker.NewTiers(tf.AnnounceTiers())
+	if err != nil {
+		fmt.Println("tracker_err:", err)
+		if !opts.DHT && len(linkPeers) == 0 {
+			os.Exit(1)
+		}
+	} else {
+		// the announcer is done with them by the time download returns
+		defer tiers.Close()
+		tiers.AllTiers = opts.AllTiers
+
+		announcer = tracker.NewAnnouncer(tiers, [20]byte(hash), peerId, client.DefaultListenPort, statsFunc)
+		announcer.OnError = func(err error) {
+			fmt.Println("tracker:", err)
+		}
+		announcer.IPv6 = publicIPv6()
+	}
+
+	// discover sends the started announce and looks the torrent up on the
+	// DHT. It runs once the progress is known, so the trackers get the right
+	// left, except for magnet links, which need peers for the metadata first.
+	// peers given in a magnet link are dialed along with the discovered ones
+	peerListDecoded := linkPeers
+	var node *dht.Node
 	defer func() {
-		err := resp.Body.Close()
-		if err != nil {
-			fmt.Println("resp_close_err:", err)
+		if node != nil {
+			stopDHT(node)
 		}
 	}()
+	discovered := false
+	discover := func() {
+		discovered = true
+
+		if announcer != nil {
+			peerListDecoded = peers.Merge(peerListDecoded, announceStarted(ctx, announcer, tiers))
+		}
+
+		// private torrents must only get peers from their trackers (BEP 27)
+		if opts.DHT && !tf.IsPrivate() {
+			var err error
+			node, err = startDHT(ctx, client.DefaultListenPort, tf.DHTNodes())
+			if err != nil {
+				fmt.Println("dht_err:", err)
+			} else {
+				found, err := dhtPeers(ctx, node, [20]byte(hash), client.DefaultListenPort)
+				if err != nil {
+					fmt.Println("dht_err:", err)
+				}
+				fmt.Printf("dht: %d peers\n", len(found))
+				peerListDecoded = peers.Merge(peerListDecoded, found)
+			}
+		}
+
+		if state != nil {
+			peerListDecoded = peers.Merge(peerListDecoded, state.PeerList())
+		}
+	}
+
+	if tf.InfoBytes == nil {
+		discover()
+		if len(peerListDecoded) == 0 {
+			fmt.Println("peers_err: no peers found")
+			os.Exit(1)
+		}
 
-	decodedResponse, err := bencode.Decode(resp.Body)
+		tf, err = fetchMetadata(peerListDecoded, [20]byte(hash), peerId, trackers)
+		if err != nil {
+			fmt.Println("metadata_err:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("torrent name=%s pieces=%d piece_len=%d size=%d\n", tf.Info.Name, len(tf.GetPieceHashes()), tf.Info.PieceLength, tf.CalculateSize())
+	}
+
+	pieceHashes := tf.GetPieceHashes()
+	priorities, err := filePriorities(tf, opts.Only, opts.Exclude, opts.Priorities)
 	if err != nil {
-		fmt.Println("bencode_err:", err)
+		fmt.Println("select_err:", err)
 		os.Exit(1)
 	}
+	skip := make([]bool, len(priorities))
+	selected := 0
+	for i, priority := range priorities {
+		skip[i] = priority == torrent.PrioritySkip
+		if !skip[i] {
+			selected++
+		}
+	}
+	if selected < len(priorities) {
+		fmt.Printf("selected %d/%d files\n", selected, len(priorities))
+	}
 
-	peerList := decodedResponse.(map[string]interface{})["peers"]
-	peerListDecoded, err := peers.Unmarshal(peerList)
+	// a single file is saved as Info.Name, the files of a multi-file torrent
+	// under a directory of that name
+	store, err := storage.NewFiles(".", tf.Info.PieceLength, tf.FileEntries(), skip)
 	if err != nil {
-		fmt.Println("peers_unmarshal_err:", err)
+		fmt.Println("storage_err:", err)
 		os.Exit(1)
 	}
-	fmt.Printf("tracker: %d peers\n", len(peerListDecoded))
+	defer store.Close()
+	have = restoreProgress(state, [20]byte(hash), store, pieceHashes, tf.Info.PieceLength, int64(tf.CalculateSize()))
 
-	pieceHashes := tf.GetPieceHashes()
-	dataBuffer := make([]byte, tf.CalculateSize())
-	workQueue := tf.CreateWorkQueue(pieceHashes, tf.CalculateSize())
+	piecePriorities := tf.PiecePriorities(priorities)
+	for i, priority := range piecePriorities {
+		if priority == torrent.PrioritySkip {
+			have.Skip(i)
+		}
+	}
+	workQueue := tf.CreateWorkQueue(pieceHashes, tf.CalculateSize(), have, piecePriorities)
 
 	var wg sync.WaitGroup
-	// fmt.Println("here are the piece Hashes: ", hashses)
-	fmt.Printf("starting %d peers\n", len(peerListDecoded))
-	for _, item := range peerListDecoded {
+	var pool *client.PeerPool
+	newClient := func(addr string) *client.Client {
 		wg.Add(1)
-		addr := fmt.Sprintf("%s:%d", item.IP.String(), item.Port)
+		c := client.New([20]byte(hash), addr, [20]byte(tf.PeerId), store, int(tf.Info.PieceLength), workQueue, &wg)
+		c.TotalLength = tf.CalculateSize()
+		c.Metadata = tf.InfoBytes
+		c.Stats = stats
+		c.Have = have
+		c.Pool = pool
+		return c
+	}
+	pool = client.NewPeerPool(func(item peers.Peer) {
+		addr := item.String()
 		fmt.Printf("spawn peer %s\n", addr)
-		client := client.New([20]byte(hash), addr, [20]byte(tf.PeerId), dataBuffer, int(tf.Info.PieceLength), workQueue, &wg)
-		go client.Run()
+		go newClient(addr).Run()
+	})
+	pool.PEX = !tf.IsPrivate()
+
+	listener, err := client.Listen(client.DefaultListenPort)
+	if err != nil {
+		// we can still download, only without incoming peers
+		fmt.Println("listen_err:", err)
+	} else {
+		defer listener.Close()
+		listener.Register([20]byte(hash), func(conn net.Conn, peerHandshake *client.Handshake) {
+			addr := conn.RemoteAddr().String()
+			if !pool.Accept(addr) {
+				conn.Close()
+				return
+			}
+			go newClient(addr).Accept(conn, peerHandshake)
+		})
+		go listener.Serve()
+	}
+
+	lsdRunning := false
+	if opts.LSD && !tf.IsPrivate() {
+		service := lsd.New(client.DefaultListenPort, func(infoHash [20]byte, peer peers.Peer) {
+			if pool.Add([]peers.Peer{peer}) > 0 {
+				fmt.Printf("lsd: found peer %s\n", peer)
+			}
+		})
+		service.Add([20]byte(hash))
+
+		err = service.Listen()
+		if err != nil {
+			fmt.Println("lsd_err:", err)
+		} else {
+			lsdRunning = true
+			go service.Run(ctx)
+		}
+	}
+
+	if !discovered {
+		discover()
+	}
+
+	// peers that find us through the listener, LSD or the DHT are as good as
+	// the ones we dial, and a seeder may well be the first of its swarm
+	if len(peerListDecoded) == 0 {
+		inbound := listener != nil || lsdRunning || node != nil
+		if !inbound && !opts.Seed && !have.Done() {
+			fmt.Println("peers_err: no peers found")
+			os.Exit(1)
+		}
+		fmt.Println("peers: none found yet, waiting for incoming peers")
+	}
+
+	fmt.Printf("starting %d peers\n", len(peerListDecoded))
+	pool.Add(peerListDecoded)
+
+	save := func() {
+		saveResume(resumeFile, [20]byte(hash), store, have, stats, pool)
+	}
+	defer save()
+	go runResume(ctx, save)
+
+	announceDone := make(chan struct{})
+	go func() {
+		if announcer != nil {
+			// peers from later announces join the pool as slots free up
+			announcer.OnPeers = func(list []peers.Peer) {
+				pool.Add(list)
+			}
+			announcer.Run(ctx)
+		}
+		close(announceDone)
+	}()
+
+	select {
+	case <-have.Complete():
+	case <-ctx.Done():
+		fmt.Println("download interrupted")
+		<-announceDone
+		return
+	}
+
+	saved, _ := storage.SafePath(".", []string{tf.Info.Name})
+	fmt.Printf("download_complete: saved %s\n", saved)
+
+	if opts.Seed {
+		fmt.Println("seeding, press Ctrl-C to stop")
+		<-ctx.Done()
 	}
 
-	wg.Wait()
+	cancel()
+	<-announceDone
+}
+
+// announceStarted sends the started announce and reports how each tracker
+// answered.
+func announceStarted(ctx context.Context, announcer *tracker.Announcer, tiers *tracker.Tiers) []peers.Peer {
+	resp, err := announcer.Announce(ctx, tracker.EventStarted)
+	for _, status := range tiers.Status() {
+		if status.LastError != nil {
+			fmt.Printf("tracker %s (tier %d): %v\n", status.URL, status.Tier, status.LastError)
+		} else if !status.LastAnnounce.IsZero() {
+			fmt.Printf("tracker %s (tier %d): %d peers\n", status.URL, status.Tier, status.Peers)
+		}
+	}
+	if err != nil {
+		fmt.Println("tracker_err: no tracker answered")
+		return nil
+	}
+
+	fmt.Printf("tracker: %d peers (seeders=%d leechers=%d interval=%s)\n", len(resp.Peers), resp.Seeders, resp.Leechers, resp.Interval)
+	return resp.Peers
+}
+
+// leftBytes returns the size of the pieces still to download. Pieces of
+// skipped files do not count, so left reaches 0, and completed is announced,
+// once the selected files are done.
+func leftBytes(have *torrent.Progress, pieceLength, length int64) int64 {
+	var res int64
+	for i := 0; i < have.Len(); i++ {
+		if have.Has(i) || have.Skipped(i) {
+			continue
+		}
+		res += min(pieceLength, length-int64(i)*pieceLength)
+	}
+	return res
+}
+
+// resolvePeers turns the host:port addresses of a magnet link's x.pe into
+// peers, leaving out those that do not resolve.
+func resolvePeers(addrs []string) []peers.Peer {
+	var res []peers.Peer
+	for _, addr := range addrs {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			fmt.Printf("magnet: peer %s: %v\n", addr, err)
+			continue
+		}
+		res = append(res, peers.Peer{IP: tcpAddr.IP, Port: uint16(tcpAddr.Port)})
+	}
+	return res
+}
 
-	fmt.Println("download_complete: saving to disk...")
-	err = os.WriteFile(tf.Info.Name, dataBuffer, 0644)
+// publicIPv6 returns a global IPv6 address of this host, so that trackers
+// reached over IPv4 can hand it out too, or nil if there is none.
+func publicIPv6() net.IP {
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		log.Fatalf("Failed to save file: %v", err)
+		return nil
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if ip.To4() == nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			return ip
+		}
+	}
+	return nil
+}
+
+// fetchMetadata asks the peers in turn for the info dictionary of a torrent
+// started from a magnet link.
+func fetchMetadata(peerList []peers.Peer, infoHash [20]byte, peerId [20]byte, trackers []string) (*torrentFile.TorrentFile, error) {
+	for _, item := range peerList {
+		addr := item.String()
+
+		infoBytes, err := client.FetchMetadata(addr, infoHash, peerId)
+		if err != nil {
+			fmt.Printf("peer %s: metadata failed: %v\n", addr, err)
+			continue
+		}
+
+		tf, err := torrentFile.FromMetadata(infoBytes, trackers)
+		if err != nil {
+			return nil, err
+		}
+		tf.PeerId = peerId[:]
+		return tf, nil
 	}
-	fmt.Printf("saved file=%s\n", tf.Info.Name)
 
+	return nil, fmt.Errorf("no peer provided the metadata")
 }
@@ -4,6 +4,13 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"strconv"
+)
+
+// sizes of one compact peer entry: address followed by a 2-byte port
+const (
+	compactSize  = 6
+	compactSize6 = 18
 )
 
 type Peer struct {
@@ -11,26 +18,20 @@ type Peer struct {
 	Port uint16
 }
 
+// String returns the peer as a dialable host:port, with IPv6 addresses in
+// brackets.
+func (p Peer) String() string {
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(int(p.Port)))
+}
+
 func Unmarshal(peersData interface{}) ([]Peer, error) {
 	switch peersValue := peersData.(type) {
 
 	// Case 1: Binary Model (most common)
 	case string:
-		const peerSize = 6
-		peersBin := []byte(peersValue)
-
-		if len(peersBin)%peerSize != 0 {
-			return nil, fmt.Errorf("received malformed binary peers")
-		}
-		numPeers := len(peersBin) / peerSize
-		peers := make([]Peer, numPeers)
-
-		for i := 0; i < numPeers; i++ {
-			offset := i * peerSize
-			peers[i].IP = net.IP(peersBin[offset : offset+4])
-			peers[i].Port = binary.BigEndian.Uint16(peersBin[offset+4 : offset+6])
-		}
-		return peers, nil
+		return unmarshalCompact([]byte(peersValue))
+	case []byte:
+		return unmarshalCompact(peersValue)
 
 	// Case 2: Dictionary Model (what you received)
 	case []interface{}:
@@ -45,13 +46,23 @@ func Unmarshal(peersData interface{}) ([]Peer, error) {
 			ipStr, ipOk := peerMap["ip"].(string)
 			portVal, portOk := peerMap["port"].(int64)
 
-			if !ipOk || !portOk {
-				// Skip malformed entries
+			if !ipOk || !portOk || portVal < 1 || portVal > 65535 {
+				// Skip malformed entries; a port out of range would wrap
+				// around to another one
 				continue
 			}
 
+			ip := net.ParseIP(ipStr)
+			if ip == nil {
+				// hostnames are allowed by the spec but we only dial addresses
+				continue
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+
 			peer := Peer{
-				IP:   net.ParseIP(ipStr),
+				IP:   ip,
 				Port: uint16(portVal),
 			}
 			peers = append(peers, peer)
@@ -61,3 +72,71 @@ func Unmarshal(peersData interface{}) ([]Peer, error) {
 
 	return nil, fmt.Errorf("peers field is in an unexpected format: %T", peersData)
 }
+
+// Unmarshal6 parses the compact IPv6 peer list of the peers6 key (BEP 7),
+// 18 bytes per peer.
+func Unmarshal6(peersData interface{}) ([]Peer, error) {
+	switch peersValue := peersData.(type) {
+	case string:
+		return unmarshalCompactSize([]byte(peersValue), compactSize6)
+	case []byte:
+		return unmarshalCompactSize(peersValue, compactSize6)
+	}
+
+	return nil, fmt.Errorf("peers6 field is in an unexpected format: %T", peersData)
+}
+
+func unmarshalCompact(peersBin []byte) ([]Peer, error) {
+	return unmarshalCompactSize(peersBin, compactSize)
+}
+
+func unmarshalCompactSize(peersBin []byte, peerSize int) ([]Peer, error) {
+	if len(peersBin)%peerSize != 0 {
+		return nil, fmt.Errorf("received malformed binary peers")
+	}
+	numPeers := len(peersBin) / peerSize
+	peers := make([]Peer, numPeers)
+	ipSize := peerSize - 2
+
+	for i := 0; i < numPeers; i++ {
+		offset := i * peerSize
+		peers[i].IP = net.IP(peersBin[offset : offset+ipSize])
+		peers[i].Port = binary.BigEndian.Uint16(peersBin[offset+ipSize : offset+peerSize])
+	}
+	return peers, nil
+}
+
+// Merge concatenates peer lists, keeping the first occurrence of each address.
+func Merge(lists ...[]Peer) []Peer {
+	var res []Peer
+	seen := make(map[string]bool)
+
+	for _, list := range lists {
+		for _, peer := range list {
+			addr := peer.String()
+			if !seen[addr] {
+				seen[addr] = true
+				res = append(res, peer)
+			}
+		}
+	}
+	return res
+}
+
+// Compact encodes peers in the compact format, IPv4 peers as 6-byte entries
+// and IPv6 peers as 18-byte entries.
+func Compact(list []Peer) (v4 []byte, v6 []byte) {
+	for _, peer := range list {
+		var port [2]byte
+		binary.BigEndian.PutUint16(port[:], peer.Port)
+
+		if ip4 := peer.IP.To4(); ip4 != nil {
+			v4 = append(v4, ip4...)
+			v4 = append(v4, port[:]...)
+		} else if ip6 := peer.IP.To16(); ip6 != nil {
+			v6 = append(v6, ip6...)
+			v6 = append(v6, port[:]...)
+		}
+	}
+	return v4, v6
+}
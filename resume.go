@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bitTorrentClient/client"
+	"bitTorrentClient/resume"
+	"bitTorrentClient/storage"
+	"bitTorrentClient/torrent"
+)
+
+// resumeInterval is how often the resume file is rewritten while the torrent
+// is active, so a crash loses little progress.
+const resumeInterval = 30 * time.Second
+
+// resumePath returns where the resume file of a torrent is kept, or "" if
+// there is no cache directory.
+func resumePath(infoHash [20]byte) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	dir = filepath.Join(dir, "bitTorrentClient", "resume")
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, hex.EncodeToString(infoHash[:])+".resume")
+}
+
+// loadResume reads the resume file at path, or returns nil if there is none.
+func loadResume(path string) *resume.State {
+	if path == "" {
+		return nil
+	}
+
+	state, err := resume.Load(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("resume:", err)
+		}
+		return nil
+	}
+	return state
+}
+
+// restoreProgress returns the pieces already on disk. The resume state is
+// trusted if the files are unchanged since it was saved; otherwise every
+// piece is checked again.
+func restoreProgress(state *resume.State, infoHash [20]byte, store *storage.Files, pieceHashes [][20]byte, pieceLength, length int64) *torrent.Progress {
+	if state != nil && state.Matches(infoHash, len(pieceHashes), resume.Stat(store.Paths())) {
+		have := torrent.NewProgress(len(pieceHashes))
+		for i := range pieceHashes {
+			if state.Bitfield[i/8]>>(7-i%8)&1 != 0 {
+				have.Set(i)
+				store.MarkComplete(i)
+			}
+		}
+		fmt.Printf("resume: %d/%d pieces on disk\n", have.Count(), have.Len())
+		return have
+	}
+
+	if state != nil {
+		fmt.Println("resume: files changed since the last run, checking every piece")
+	}
+	have := storage.Check(store, pieceHashes, pieceLength, length, 0).Have
+	if have.Count() > 0 {
+		fmt.Printf("resume: %d/%d pieces verified on disk\n", have.Count(), have.Len())
+	}
+	return have
+}
+
+// saveResume writes the progress of a torrent to path.
+func saveResume(path string, infoHash [20]byte, store *storage.Files, have *torrent.Progress, stats *torrent.Stats, pool *client.PeerPool) {
+	if path == "" {
+		return
+	}
+
+	state := &resume.State{
+		InfoHash:   infoHash[:],
+		Bitfield:   have.Bitfield(),
+		Files:      resume.Stat(store.Paths()),
+		Uploaded:   stats.Uploaded.Load(),
+		Downloaded: stats.Downloaded.Load(),
+	}
+	state.SetPeers(pool.ConnectedPeers())
+
+	err := state.Save(path)
+	if err != nil {
+		fmt.Println("resume:", err)
+	}
+}
+
+// runResume saves the progress every resumeInterval until ctx is cancelled.
+func runResume(ctx context.Context, save func()) {
+	ticker := time.NewTicker(resumeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			save()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Package resume saves the progress of a download between runs, so that a
+// restarted download only fetches the pieces it is missing.
+package resume
+
+import (
+	"fmt"
+	"os"
+
+	"bitTorrentClient/bencode"
+	"bitTorrentClient/peers"
+)
+
+// State is the bencoded fast-resume file of a torrent.
+type State struct {
+	InfoHash []byte `bencode:"info-hash"`
+	// Bitfield holds the verified pieces in the wire format of MsgBitfield.
+	Bitfield []byte `bencode:"bitfield"`
+	// Files records the size and modification time of every file when the
+	// state was saved. If they changed since, the bitfield cannot be trusted.
+	Files      []FileStat `bencode:"files"`
+	Uploaded   int64      `bencode:"uploaded"`
+	Downloaded int64      `bencode:"downloaded"`
+	// the peers we were connected to, compact like in tracker responses
+	Peers  []byte `bencode:"peers,omitempty"`
+	Peers6 []byte `bencode:"peers6,omitempty"`
+}
+
+// FileStat is the size and modification time of a file, or -1 and 0 if it
+// does not exist.
+type FileStat struct {
+	Length int64 `bencode:"length"`
+	MTime  int64 `bencode:"mtime"`
+}
+
+// Stat returns the FileStat of each path.
+func Stat(paths []string) []FileStat {
+	res := make([]FileStat, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			res[i] = FileStat{Length: -1}
+			continue
+		}
+		res[i] = FileStat{Length: info.Size(), MTime: info.ModTime().UnixNano()}
+	}
+	return res
+}
+
+// Matches reports whether the state belongs to the torrent and its files are
+// unchanged since it was saved.
+func (s *State) Matches(infoHash [20]byte, numPieces int, files []FileStat) bool {
+	if string(s.InfoHash) != string(infoHash[:]) || len(s.Bitfield) != (numPieces+7)/8 {
+		return false
+	}
+	if len(s.Files) != len(files) {
+		return false
+	}
+	for i := range files {
+		if s.Files[i] != files[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetPeers records the peers to try first next time.
+func (s *State) SetPeers(list []peers.Peer) {
+	s.Peers, s.Peers6 = peers.Compact(list)
+}
+
+// PeerList returns the peers saved with the state.
+func (s *State) PeerList() []peers.Peer {
+	list, _ := peers.Unmarshal(s.Peers)
+	list6, _ := peers.Unmarshal6(s.Peers6)
+	return peers.Merge(list, list6)
+}
+
+// Save writes the state to path, replacing it atomically.
+func (s *State) Save(path string) error {
+	data, err := bencode.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads a state written by Save.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s State
+	err = bencode.Unmarshal(data, &s)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading resume file %s: %v", path, err)
+	}
+	return &s, nil
+}
@@ -0,0 +1,103 @@
+package resume
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+func TestSaveAndMatch(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	for _, path := range paths {
+		err := os.WriteFile(path, []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash := [20]byte{1, 2, 3}
+	s := &State{
+		InfoHash:   hash[:],
+		Bitfield:   []byte{0b10100000, 0b10000000},
+		Files:      Stat(paths),
+		Uploaded:   10,
+		Downloaded: 20,
+	}
+	s.SetPeers([]peers.Peer{
+		{IP: net.IPv4(10, 0, 0, 1), Port: 6881},
+		{IP: net.ParseIP("2001:db8::1"), Port: 6882},
+	})
+
+	statePath := filepath.Join(dir, "state")
+	err := s.Save(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, s) {
+		t.Errorf("Load = %+v, want %+v", loaded, s)
+	}
+	if got := loaded.PeerList(); len(got) != 2 || got[0].String() != "10.0.0.1:6881" || got[1].String() != "[2001:db8::1]:6882" {
+		t.Errorf("got peers %v", got)
+	}
+
+	if !loaded.Matches(hash, 9, Stat(paths)) {
+		t.Fatal("state does not match its own files")
+	}
+	if loaded.Matches([20]byte{9}, 9, Stat(paths)) {
+		t.Error("state matches another torrent")
+	}
+	if loaded.Matches(hash, 17, Stat(paths)) {
+		t.Error("state matches a torrent with another piece count")
+	}
+	if loaded.Matches(hash, 9, Stat(paths[:1])) {
+		t.Error("state matches with a file less")
+	}
+
+	// a file touched since the state was saved invalidates it, even with the
+	// same size
+	later := time.Now().Add(time.Hour)
+	err = os.Chtimes(paths[1], later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Matches(hash, 9, Stat(paths)) {
+		t.Error("state matches after a file's mtime changed")
+	}
+
+	// as does a missing file
+	loaded.Files = Stat(paths)
+	err = os.Remove(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Matches(hash, 9, Stat(paths)) {
+		t.Error("state matches after a file was removed")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Load(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Load of a missing file succeeded")
+	}
+
+	path := filepath.Join(dir, "state")
+	err := os.WriteFile(path, []byte("d9:info-hashi1ee"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load of a malformed state succeeded")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"bitTorrentClient/torrentFile"
+	"bitTorrentClient/tracker"
+)
+
+// maxHashesPerScrape keeps a single scrape request within what UDP trackers
+// accept; HTTP trackers get the same batches.
+const maxHashesPerScrape = 74
+
+func runScrape(args []string) error {
+	flags := flag.NewFlagSet("scrape", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 30*time.Second, "time allowed for each tracker")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: scrape [--timeout 30s] <path-to-file>...")
+	}
+
+	// every tracker is asked once for all the torrents it serves
+	names := make(map[[20]byte]string)
+	hashesByTracker := make(map[string][][20]byte)
+	var trackerOrder []string
+
+	for _, path := range flags.Args() {
+		tf, err := torrentFile.Open(path)
+		if err != nil {
+			return err
+		}
+
+		hash, err := tf.GetInfoHash()
+		if err != nil {
+			return err
+		}
+		names[[20]byte(hash)] = tf.Info.Name
+
+		for _, announceURL := range tf.Trackers() {
+			if _, ok := hashesByTracker[announceURL]; !ok {
+				trackerOrder = append(trackerOrder, announceURL)
+			}
+			hashesByTracker[announceURL] = append(hashesByTracker[announceURL], [20]byte(hash))
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TRACKER\tNAME\tSEEDERS\tCOMPLETED\tLEECHERS")
+
+	for _, announceURL := range trackerOrder {
+		results, err := scrapeTracker(announceURL, hashesByTracker[announceURL], *timeout)
+		if err != nil {
+			fmt.Fprintf(w, "%s\terror: %v\t\t\t\n", announceURL, err)
+			continue
+		}
+
+		for _, hash := range hashesByTracker[announceURL] {
+			result, ok := results[hash]
+			if !ok {
+				fmt.Fprintf(w, "%s\t%s\tunknown\t\t\n", announceURL, names[hash])
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", announceURL, names[hash], result.Seeders, result.Completed, result.Leechers)
+		}
+	}
+
+	return w.Flush()
+}
+
+func scrapeTracker(announceURL string, hashes [][20]byte, timeout time.Duration) (map[[20]byte]tracker.ScrapeResult, error) {
+	trk, err := tracker.New(announceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	scraper, ok := trk.(tracker.Scraper)
+	if !ok {
+		return nil, tracker.ErrScrapeUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res := make(map[[20]byte]tracker.ScrapeResult)
+	for start := 0; start < len(hashes); start += maxHashesPerScrape {
+		batch := hashes[start:min(start+maxHashesPerScrape, len(hashes))]
+
+		results, err := scraper.Scrape(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for hash, result := range results {
+			res[hash] = result
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"bitTorrentClient/torrent"
+	"bitTorrentClient/torrentFile"
+)
+
+// priorityRule is a --priority flag: the files matching pattern get
+// priority.
+type priorityRule struct {
+	pattern  string
+	priority torrent.Priority
+}
+
+// parsePriorityRules reads --priority flags of the form glob=level, where
+// level is low, normal or high. Files are skipped with --exclude instead.
+func parsePriorityRules(values []string) ([]priorityRule, error) {
+	var res []priorityRule
+	for _, value := range values {
+		// the level is after the last "=", as the glob may contain one
+		i := strings.LastIndex(value, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid priority %q: want glob=level", value)
+		}
+
+		priority, err := torrent.ParsePriority(value[i+1:])
+		if err != nil || priority == torrent.PrioritySkip {
+			return nil, fmt.Errorf("invalid priority %q: the level must be low, normal or high", value)
+		}
+		res = append(res, priorityRule{pattern: value[:i], priority: priority})
+	}
+	return res, nil
+}
+
+// filePriorities applies the --only, --exclude and --priority globs to the
+// files of a torrent. A glob matches a file's path inside the torrent, with
+// "/" separators, or its base name. With no globs every file is wanted at
+// normal priority; when several --priority globs match, the last one wins.
+// The globs must have been checked with checkPatterns.
+func filePriorities(tf *torrentFile.TorrentFile, only, exclude []string, rules []priorityRule) ([]torrent.Priority, error) {
+	entries := tf.FileEntries()
+	res := make([]torrent.Priority, len(entries))
+	wanted := 0
+	for i, entry := range entries {
+		name := path.Join(entry.Path...)
+		if len(tf.Info.Files) > 0 {
+			// the paths of a multi-file torrent start with its directory
+			name = path.Join(entry.Path[1:]...)
+		}
+
+		res[i] = torrent.PriorityNormal
+		for _, rule := range rules {
+			if matchAny([]string{rule.pattern}, name) {
+				res[i] = rule.priority
+			}
+		}
+		if len(only) > 0 && !matchAny(only, name) {
+			res[i] = torrent.PrioritySkip
+		}
+		if matchAny(exclude, name) {
+			res[i] = torrent.PrioritySkip
+		}
+		if res[i] != torrent.PrioritySkip {
+			wanted++
+		}
+	}
+
+	if wanted == 0 {
+		return nil, fmt.Errorf("no file of the torrent is selected")
+	}
+	return res, nil
+}
+
+// checkPatterns reports the first malformed glob.
+func checkPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"bitTorrentClient/torrent"
+	"bitTorrentClient/torrentFile"
+)
+
+// selectionTorrent has four files of whole pieces: readme is piece 0, a.mkv
+// pieces 1-2, b.mkv pieces 3-4 and extra.nfo piece 5.
+func selectionTorrent() *torrentFile.TorrentFile {
+	return &torrentFile.TorrentFile{Info: torrentFile.Info{
+		Name:        "t",
+		PieceLength: 4,
+		Pieces:      make([]byte, 6*20),
+		Files: []torrentFile.FileInfo{
+			{Length: 4, Path: []string{"docs", "readme.txt"}},
+			{Length: 8, Path: []string{"video", "a.mkv"}},
+			{Length: 8, Path: []string{"video", "b.mkv"}},
+			{Length: 4, Path: []string{"extra.nfo"}},
+		},
+	}}
+}
+
+func TestFilePriorities(t *testing.T) {
+	const (
+		skip   = torrent.PrioritySkip
+		low    = torrent.PriorityLow
+		normal = torrent.PriorityNormal
+		high   = torrent.PriorityHigh
+	)
+
+	tests := []struct {
+		name       string
+		only       []string
+		exclude    []string
+		priorities []string
+		want       []torrent.Priority
+	}{
+		{"everything", nil, nil, nil, []torrent.Priority{normal, normal, normal, normal}},
+		{"only by base name", []string{"*.mkv"}, nil, nil, []torrent.Priority{skip, normal, normal, skip}},
+		{"only by path", []string{"docs/*"}, nil, nil, []torrent.Priority{normal, skip, skip, skip}},
+		{"exclude", nil, []string{"*.nfo", "video/b.mkv"}, nil, []torrent.Priority{normal, normal, skip, skip}},
+		{"exclude wins over only", []string{"*.mkv"}, []string{"a.mkv"}, nil, []torrent.Priority{skip, skip, normal, skip}},
+		{"priorities", nil, nil, []string{"*.mkv=low", "b.mkv=high"}, []torrent.Priority{normal, low, high, normal}},
+		{"excluded files stay skipped", nil, []string{"readme.txt"}, []string{"*=high"}, []torrent.Priority{skip, high, high, high}},
+	}
+
+	for _, test := range tests {
+		rules, err := parsePriorityRules(test.priorities)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got, err := filePriorities(selectionTorrent(), test.only, test.exclude, rules)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := filePriorities(selectionTorrent(), []string{"*.iso"}, nil, nil); err == nil {
+		t.Error("a selection of no file succeeded")
+	}
+}
+
+func TestParsePriorityRules(t *testing.T) {
+	rules, err := parsePriorityRules([]string{"a=b.txt=high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].pattern != "a=b.txt" || rules[0].priority != torrent.PriorityHigh {
+		t.Errorf("got rules %+v", rules)
+	}
+
+	for _, value := range []string{"*.mkv", "*.mkv=skip", "*.mkv=urgent", "*.mkv="} {
+		if _, err := parsePriorityRules([]string{value}); err == nil {
+			t.Errorf("parsePriorityRules(%q) succeeded", value)
+		}
+	}
+
+	if err := checkPatterns([]string{"*.mkv", "[a-"}); err == nil {
+		t.Error("checkPatterns accepted a malformed glob")
+	}
+}
+
+func TestWorkQueueByPriority(t *testing.T) {
+	tf := selectionTorrent()
+	rules, err := parsePriorityRules([]string{"b.mkv=high", "readme.txt=low"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	priorities, err := filePriorities(tf, nil, []string{"*.nfo"}, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// piece 3 is already verified
+	have := torrent.NewProgress(6)
+	have.Set(3)
+	queue := tf.CreateWorkQueue(tf.GetPieceHashes(), tf.CalculateSize(), have, tf.PiecePriorities(priorities))
+
+	take := func() int {
+		work, ok := queue.Take()
+		if !ok {
+			return -1
+		}
+		return work.Index
+	}
+
+	// b.mkv first, then a.mkv, then the readme; the excluded nfo never
+	got := []int{take(), take()}
+	if !reflect.DeepEqual(got, []int{4, 1}) {
+		t.Fatalf("got pieces %v first, want [4 1]", got)
+	}
+
+	// a piece put back goes ahead of the lower priorities but not of the
+	// higher ones left
+	work := &torrent.PieceWork{Index: 4, Priority: torrent.PriorityHigh}
+	queue.Put(&torrent.PieceWork{Index: 1, Priority: torrent.PriorityNormal})
+	queue.Put(work)
+	got = append(got, take(), take(), take(), take(), take())
+	if want := []int{4, 1, 4, 2, 1, 0, -1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got pieces %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"bitTorrentClient/torrentFile"
+)
+
+// torrentSummary is the --json form of the show command.
+type torrentSummary struct {
+	Name         string        `json:"name"`
+	InfoHash     string        `json:"info_hash"`
+	PieceLength  int64         `json:"piece_length"`
+	Pieces       int           `json:"pieces"`
+	TotalSize    int           `json:"total_size"`
+	Private      bool          `json:"private"`
+	Announce     string        `json:"announce,omitempty"`
+	AnnounceList [][]string    `json:"announce_list,omitempty"`
+	CreationDate string        `json:"creation_date,omitempty"`
+	Comment      string        `json:"comment,omitempty"`
+	CreatedBy    string        `json:"created_by,omitempty"`
+	Encoding     string        `json:"encoding,omitempty"`
+	WebSeeds     []string      `json:"web_seeds,omitempty"`
+	Files        []fileSummary `json:"files"`
+}
+
+type fileSummary struct {
+	Path       string `json:"path"`
+	Length     int64  `json:"length"`
+	FirstPiece int    `json:"first_piece"`
+	LastPiece  int    `json:"last_piece"`
+}
+
+func runShow(args []string) error {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the metadata as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: show [--json] <path-to-file>")
+	}
+
+	tf, err := torrentFile.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	summary, err := summarize(tf)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	}
+
+	printSummary(summary)
+	return nil
+}
+
+func summarize(tf *torrentFile.TorrentFile) (*torrentSummary, error) {
+	hash, err := tf.GetInfoHash()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &torrentSummary{
+		Name:         tf.Info.Name,
+		InfoHash:     hex.EncodeToString(hash),
+		PieceLength:  tf.Info.PieceLength,
+		Pieces:       len(tf.GetPieceHashes()),
+		TotalSize:    tf.CalculateSize(),
+		Private:      tf.IsPrivate(),
+		Announce:     tf.Announce,
+		AnnounceList: tf.AnnounceList,
+		Comment:      tf.Comment,
+		CreatedBy:    tf.CreatedBy,
+		Encoding:     tf.Encoding,
+		WebSeeds:     tf.WebSeeds(),
+		Files:        []fileSummary{},
+	}
+
+	if tf.CreationDate != 0 {
+		summary.CreationDate = time.Unix(tf.CreationDate, 0).UTC().Format(time.RFC3339)
+	}
+
+	for _, entry := range tf.FileEntries() {
+		summary.Files = append(summary.Files, fileSummary{
+			Path:       filepath.Join(entry.Path...),
+			Length:     entry.Length,
+			FirstPiece: entry.FirstPiece,
+			LastPiece:  entry.LastPiece,
+		})
+	}
+
+	return summary, nil
+}
+
+func printSummary(s *torrentSummary) {
+	fmt.Printf("name:          %s\n", s.Name)
+	fmt.Printf("infohash:      %s\n", s.InfoHash)
+	fmt.Printf("size:          %s (%d bytes)\n", humanSize(int64(s.TotalSize)), s.TotalSize)
+	fmt.Printf("pieces:        %d x %s\n", s.Pieces, humanSize(s.PieceLength))
+	fmt.Printf("private:       %t\n", s.Private)
+	if s.CreationDate != "" {
+		fmt.Printf("created:       %s\n", s.CreationDate)
+	}
+	if s.CreatedBy != "" {
+		fmt.Printf("created by:    %s\n", s.CreatedBy)
+	}
+	if s.Comment != "" {
+		fmt.Printf("comment:       %s\n", s.Comment)
+	}
+	if s.Encoding != "" {
+		fmt.Printf("encoding:      %s\n", s.Encoding)
+	}
+
+	fmt.Println("trackers:")
+	if len(s.AnnounceList) == 0 && s.Announce != "" {
+		fmt.Printf("  tier 0: %s\n", s.Announce)
+	}
+	for i, tier := range s.AnnounceList {
+		for _, tracker := range tier {
+			fmt.Printf("  tier %d: %s\n", i, tracker)
+		}
+	}
+
+	if len(s.WebSeeds) > 0 {
+		fmt.Println("web seeds:")
+		for _, seed := range s.WebSeeds {
+			fmt.Printf("  %s\n", seed)
+		}
+	}
+
+	fmt.Println("files:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, file := range s.Files {
+		pieces := "-"
+		if file.LastPiece >= file.FirstPiece {
+			pieces = fmt.Sprintf("%d-%d", file.FirstPiece, file.LastPiece)
+		}
+		fmt.Fprintf(w, "  %s\t%s\t\t%s\n", humanSize(file.Length), pieces, file.Path)
+	}
+	w.Flush()
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
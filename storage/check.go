@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"runtime"
+	"sort"
+	"sync"
+
+	"bitTorrentClient/torrent"
+	"bitTorrentClient/torrentFile"
+)
+
+// CheckResult is what Check found on disk.
+type CheckResult struct {
+	// Have holds the pieces that match their hash.
+	Have *torrent.Progress
+	// Corrupt lists, in order, the pieces that were read but do not match
+	// their hash. Pieces that cannot be read or are all zeros, as left by a
+	// download that never got to them, count as missing instead.
+	Corrupt []int
+}
+
+// Check reads every piece back from s and compares it with its hash, using
+// workers goroutines, or one per CPU if workers is not positive. The pieces
+// that match are marked complete in s.
+func Check(s Storage, hashes [][20]byte, pieceLength, length int64, workers int) *CheckResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	res := &CheckResult{Have: torrent.NewProgress(len(hashes))}
+	var mu sync.Mutex
+
+	pieces := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			buf := make([]byte, pieceLength)
+			for i := range pieces {
+				size := min(pieceLength, length-int64(i)*pieceLength)
+				if size <= 0 {
+					continue
+				}
+
+				_, err := s.ReadAt(buf[:size], i, 0)
+				if err != nil {
+					continue
+				}
+
+				sum := sha1.Sum(buf[:size])
+				if bytes.Equal(sum[:], hashes[i][:]) {
+					res.Have.Set(i)
+					s.MarkComplete(i)
+				} else if !allZero(buf[:size]) {
+					mu.Lock()
+					res.Corrupt = append(res.Corrupt, i)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := range hashes {
+		pieces <- i
+	}
+	close(pieces)
+	wg.Wait()
+
+	sort.Ints(res.Corrupt)
+	return res
+}
+
+// FileCompletion returns, for each file, how many of its bytes lie in
+// verified pieces.
+func (r *CheckResult) FileCompletion(entries []torrentFile.FileEntry, pieceLength int64) []int64 {
+	res := make([]int64, len(entries))
+	for i, entry := range entries {
+		for piece := entry.FirstPiece; piece <= entry.LastPiece; piece++ {
+			if !r.Have.Has(piece) {
+				continue
+			}
+			start := max(int64(piece)*pieceLength, entry.Offset)
+			end := min(int64(piece+1)*pieceLength, entry.Offset+entry.Length)
+			res[i] += end - start
+		}
+	}
+	return res
+}
+
+func allZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
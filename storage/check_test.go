@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	data := testData()
+
+	var hashes [][20]byte
+	for begin := 0; begin < len(data); begin += 4 {
+		hashes = append(hashes, sha1.Sum(data[begin:min(begin+4, len(data))]))
+	}
+
+	// piece 1 is corrupt, piece 2 was never written and piece 4 is cut short
+	files := map[string]string{
+		"a":     "abcde",
+		"b":     "",
+		"dir/c": "fXh\x00\x00\x00\x00mno",
+		"d":     "p",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, "t", filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, workers := range []int{1, 3, 0} {
+		f, err := OpenFiles(dir, 4, testEntries())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := Check(f, hashes, 4, int64(len(data)), workers)
+		f.Close()
+
+		var have []int
+		for i := range hashes {
+			if res.Have.Has(i) {
+				have = append(have, i)
+			}
+			if res.Have.Has(i) != f.Completed(i) {
+				t.Errorf("workers=%d: piece %d verified=%v but marked complete=%v", workers, i, res.Have.Has(i), f.Completed(i))
+			}
+		}
+		// the zeroed and unreadable pieces are missing, not corrupt
+		if !reflect.DeepEqual(have, []int{0, 3}) || !reflect.DeepEqual(res.Corrupt, []int{1}) {
+			t.Errorf("workers=%d: got have %v and corrupt %v, want [0 3] and [1]", workers, have, res.Corrupt)
+		}
+
+		completion := res.FileCompletion(testEntries(), 4)
+		if !reflect.DeepEqual(completion, []int64{4, 0, 3, 1}) {
+			t.Errorf("workers=%d: got file completion %v, want [4 0 3 1]", workers, completion)
+		}
+	}
+}
+
+func TestCheckNothingOnDisk(t *testing.T) {
+	f, err := OpenFiles(t.TempDir(), 4, testEntries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	hashes := make([][20]byte, 5)
+	res := Check(f, hashes, 4, int64(len(testData())), 2)
+	if res.Have.Count() != 0 || len(res.Corrupt) != 0 {
+		t.Errorf("got %d verified and corrupt %v without any file", res.Have.Count(), res.Corrupt)
+	}
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"bitTorrentClient/torrentFile"
+)
+
+// Files stores a torrent as the files it describes, each piece split across
+// the files it covers. Files are created on their first write.
+type Files struct {
+	layout
+	pieceSet
+	files    []*fileSpan
+	readOnly bool
+}
+
+type fileSpan struct {
+	path   string
+	offset int64
+	length int64
+
+	mu   sync.Mutex
+	file *os.File
+	// writable is set once the file was opened for writing; a handle opened
+	// for reading before that is kept in readOnly, as it may still be in use
+	writable bool
+	readOnly *os.File
+}
+
+// NewFiles lays the files of a torrent out under dir. Every path is
+// sanitised (see SafePath). Empty files are created right away since no
+// piece will ever write them, unless skip is set for them; skip may be nil.
+// A skipped file is only created if a piece it shares with a wanted file is
+// written.
+func NewFiles(dir string, pieceLength int64, entries []torrentFile.FileEntry, skip []bool) (*Files, error) {
+	f, err := newFiles(dir, pieceLength, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, span := range f.files {
+		if span.length == 0 && (i >= len(skip) || !skip[i]) {
+			_, err := span.open(true)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+func newFiles(dir string, pieceLength int64, entries []torrentFile.FileEntry) (*Files, error) {
+	if pieceLength <= 0 {
+		return nil, fmt.Errorf("invalid piece length %d", pieceLength)
+	}
+
+	var length int64
+	var files []*fileSpan
+	for _, entry := range entries {
+		path, err := SafePath(dir, entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Length < 0 {
+			return nil, fmt.Errorf("invalid length %d of %s", entry.Length, path)
+		}
+
+		files = append(files, &fileSpan{path: path, offset: length, length: entry.Length})
+		length += entry.Length
+	}
+
+	f := &Files{
+		layout: layout{pieceLength: pieceLength, length: length},
+		files:  files,
+	}
+	f.completed = make([]bool, f.numPieces())
+	return f, nil
+}
+
+// OpenFiles is NewFiles for data that is only read, such as when checking
+// it: nothing is created and writes fail.
+func OpenFiles(dir string, pieceLength int64, entries []torrentFile.FileEntry) (*Files, error) {
+	f, err := newFiles(dir, pieceLength, entries)
+	if err != nil {
+		return nil, err
+	}
+	f.readOnly = true
+	return f, nil
+}
+
+// Path returns where the file at index i of the torrent is stored.
+func (f *Files) Path(i int) string {
+	return f.files[i].path
+}
+
+// Paths returns where each file of the torrent is stored, in torrent order.
+func (f *Files) Paths() []string {
+	res := make([]string, len(f.files))
+	for i, span := range f.files {
+		res[i] = span.path
+	}
+	return res
+}
+
+func (f *Files) ReadAt(p []byte, piece, begin int) (int, error) {
+	offset, err := f.offset(piece, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	return f.each(p, offset, func(span *fileSpan, p []byte, off int64) (int, error) {
+		file, err := span.open(false)
+		if err != nil {
+			return 0, err
+		}
+		return file.ReadAt(p, off)
+	})
+}
+
+func (f *Files) WriteAt(p []byte, piece, begin int) (int, error) {
+	if f.readOnly {
+		return 0, fmt.Errorf("storage is read-only")
+	}
+
+	offset, err := f.offset(piece, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	return f.each(p, offset, func(span *fileSpan, p []byte, off int64) (int, error) {
+		file, err := span.open(true)
+		if err != nil {
+			return 0, err
+		}
+		return file.WriteAt(p, off)
+	})
+}
+
+func (f *Files) MarkComplete(piece int) error {
+	return f.mark(piece)
+}
+
+// Close flushes the files written to disk and closes every open file.
+func (f *Files) Close() error {
+	var errs []error
+	for _, span := range f.files {
+		err := span.close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// each calls fn for the part of p that falls in each file, starting at offset
+// of the torrent's data.
+func (f *Files) each(p []byte, offset int64, fn func(span *fileSpan, p []byte, off int64) (int, error)) (int, error) {
+	// the first file that ends after offset; empty files end where they begin
+	// and are skipped
+	i := sort.Search(len(f.files), func(i int) bool {
+		return f.files[i].offset+f.files[i].length > offset
+	})
+
+	n := 0
+	for ; n < len(p) && i < len(f.files); i++ {
+		span := f.files[i]
+		off := offset + int64(n) - span.offset
+		size := min(int64(len(p)-n), span.length-off)
+		if size <= 0 {
+			continue
+		}
+
+		written, err := fn(span, p[n:n+int(size)], off)
+		n += written
+		if err != nil {
+			return n, fmt.Errorf("error while accessing %s: %v", span.path, err)
+		}
+	}
+	return n, nil
+}
+
+// open returns the file, opening it on first use. Opening for writing
+// creates the file and its directories and sizes it.
+func (s *fileSpan) open(write bool) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil && (s.writable || !write) {
+		return s.file, nil
+	}
+
+	if !write {
+		file, err := os.Open(s.path)
+		if err != nil {
+			return nil, err
+		}
+		s.file = file
+		return file, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err == nil && info.Size() != s.length {
+		err = file.Truncate(s.length)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s.readOnly = s.file
+	s.file = file
+	s.writable = true
+	return file, nil
+}
+
+func (s *fileSpan) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readOnly != nil {
+		s.readOnly.Close()
+		s.readOnly = nil
+	}
+	if s.file == nil {
+		return nil
+	}
+
+	var err error
+	if s.writable {
+		err = s.file.Sync()
+	}
+	closeErr := s.file.Close()
+	s.file = nil
+	s.writable = false
+
+	if err != nil {
+		return fmt.Errorf("error while syncing %s: %v", s.path, err)
+	}
+	return closeErr
+}
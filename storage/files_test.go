@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitTorrentClient/torrentFile"
+)
+
+// testEntries describes 18 bytes in four files, one of them empty, split
+// into pieces of 4 bytes that cross every file boundary.
+func testEntries() []torrentFile.FileEntry {
+	tf := &torrentFile.TorrentFile{Info: torrentFile.Info{
+		Name:        "t",
+		PieceLength: 4,
+		Files: []torrentFile.FileInfo{
+			{Length: 5, Path: []string{"a"}},
+			{Length: 0, Path: []string{"b"}},
+			{Length: 10, Path: []string{"dir", "c"}},
+			{Length: 3, Path: []string{"d"}},
+		},
+	}}
+	return tf.FileEntries()
+}
+
+func testData() []byte {
+	return []byte("abcdefghijklmnopqr")
+}
+
+func TestFilesLayout(t *testing.T) {
+	dir := t.TempDir()
+	data := testData()
+
+	f, err := NewFiles(dir, 4, testEntries(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the empty file exists before any piece is written
+	if _, err := os.Stat(filepath.Join(dir, "t", "b")); err != nil {
+		t.Errorf("empty file not created: %v", err)
+	}
+
+	for piece := 0; piece*4 < len(data); piece++ {
+		block := data[piece*4 : min(piece*4+4, len(data))]
+		n, err := f.WriteAt(block, piece, 0)
+		if err != nil || n != len(block) {
+			t.Fatalf("WriteAt piece %d: wrote %d bytes, %v", piece, n, err)
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"a":     "abcde",
+		"b":     "",
+		"dir/c": "fghijklmno",
+		"d":     "pqr",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dir, "t", filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Errorf("file %s holds %q (%v), want %q", name, got, err, content)
+		}
+	}
+
+	// blocks are read back across the file boundaries
+	f, err = OpenFiles(dir, 4, testEntries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	reads := []struct {
+		piece, begin, length int
+	}{
+		{1, 0, 4}, // a and c around the empty b
+		{1, 1, 2},
+		{3, 2, 2}, // the last bytes of c
+		{3, 0, 4}, // c and d
+		{4, 0, 2}, // the short last piece
+	}
+	for _, read := range reads {
+		buf := make([]byte, read.length)
+		n, err := f.ReadAt(buf, read.piece, read.begin)
+		offset := read.piece*4 + read.begin
+		if err != nil || n != read.length || !bytes.Equal(buf, data[offset:offset+read.length]) {
+			t.Errorf("ReadAt piece %d begin %d: got %q (%d, %v), want %q", read.piece, read.begin, buf, n, err, data[offset:offset+read.length])
+		}
+	}
+
+	outOfRange := []struct {
+		piece, begin, length int
+	}{
+		{4, 0, 3}, // past the end of the data
+		{0, 2, 3}, // past the end of the piece
+		{5, 0, 1},
+		{-1, 0, 1},
+	}
+	for _, read := range outOfRange {
+		_, err := f.ReadAt(make([]byte, read.length), read.piece, read.begin)
+		if err == nil {
+			t.Errorf("ReadAt piece %d begin %d length %d succeeded", read.piece, read.begin, read.length)
+		}
+	}
+
+	if _, err := f.WriteAt([]byte("x"), 0, 0); err == nil {
+		t.Error("WriteAt on read-only files succeeded")
+	}
+}
+
+func TestFilesSkip(t *testing.T) {
+	dir := t.TempDir()
+
+	// skipped files are only created when a piece they share is written
+	f, err := NewFiles(dir, 4, testEntries(), []bool{false, true, false, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteAt([]byte("abcd"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "d", "dir"} {
+		if _, err := os.Stat(filepath.Join(dir, "t", name)); err == nil {
+			t.Errorf("%s was created", name)
+		}
+	}
+
+	_, err = f.WriteAt([]byte("mnop"), 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "t", "d")); err != nil {
+		t.Errorf("d not created by the piece it shares with c: %v", err)
+	}
+}
+
+func TestSafePath(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		components []string
+		want       string
+	}{
+		{[]string{"a", "b.txt"}, "a/b.txt"},
+		{[]string{".."}, "_"},
+		{[]string{"..", "..", "etc", "passwd"}, "_/_/etc/passwd"},
+		{[]string{"/etc/passwd"}, "_etc_passwd"},
+		{[]string{"a/../../b"}, "a_.._.._b"},
+		{[]string{`..\..\b`}, ".._.._b"},
+		{[]string{"C:", "x"}, "C_/x"},
+		{[]string{"", ".", "..."}, "_/_/_"},
+		{[]string{"con.txt", "nul"}, "_con.txt/_nul"},
+		{[]string{"a\x00b"}, "a_b"},
+	}
+
+	for _, test := range tests {
+		got, err := SafePath(dir, test.components)
+		if err != nil {
+			t.Errorf("SafePath(%q) failed: %v", test.components, err)
+			continue
+		}
+		want := filepath.Join(dir, filepath.FromSlash(test.want))
+		if got != want {
+			t.Errorf("SafePath(%q) = %q, want %q", test.components, got, want)
+		}
+		if !strings.HasPrefix(got, dir+string(filepath.Separator)) {
+			t.Errorf("SafePath(%q) = %q is outside %s", test.components, got, dir)
+		}
+	}
+
+	if _, err := SafePath(dir, nil); err == nil {
+		t.Error("SafePath of no components succeeded")
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// reservedNames cannot be used as file names on Windows, with or without an
+// extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeComponent makes one path component of a torrent safe to create:
+// separators and control characters are replaced, "." and ".." and empty
+// names become "_", and reserved names get a "_" prefix.
+func SanitizeComponent(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':':
+			return '_'
+		case r < 0x20 || r == 0x7f:
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Windows drops trailing dots and spaces, which would turn "..." into
+	// ".." there
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+
+	base, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+	return name
+}
+
+// SafePath joins the path components of a torrent file under dir, after
+// sanitising each of them. The result always lies inside dir.
+func SafePath(dir string, components []string) (string, error) {
+	if len(components) == 0 {
+		return "", fmt.Errorf("empty file path")
+	}
+
+	parts := make([]string, 0, len(components)+1)
+	parts = append(parts, dir)
+	for _, component := range components {
+		parts = append(parts, SanitizeComponent(component))
+	}
+	path := filepath.Join(parts...)
+
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file path %q escapes %s", strings.Join(components, "/"), dir)
+	}
+	return path, nil
+}
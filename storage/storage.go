@@ -0,0 +1,71 @@
+// Package storage keeps the data of a torrent while it downloads, so that
+// verified pieces go to disk instead of staying in memory.
+package storage
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Storage holds the pieces of a torrent. Offsets are given per piece; an
+// implementation maps them onto its own layout. It must be safe for use by
+// several peer connections at once.
+type Storage interface {
+	// ReadAt reads len(p) bytes of piece starting at begin.
+	ReadAt(p []byte, piece, begin int) (int, error)
+	// WriteAt writes p into piece starting at begin.
+	WriteAt(p []byte, piece, begin int) (int, error)
+	// MarkComplete records that piece has been written and verified.
+	MarkComplete(piece int) error
+	Close() error
+}
+
+// layout maps piece offsets onto the torrent's data as one contiguous range.
+type layout struct {
+	pieceLength int64
+	length      int64
+}
+
+// offset returns where n bytes of piece starting at begin sit in the data.
+func (l layout) offset(piece, begin, n int) (int64, error) {
+	if piece < 0 || begin < 0 || int64(begin)+int64(n) > l.pieceLength {
+		return 0, fmt.Errorf("block begin=%d length=%d of piece #%d is out of range", begin, n, piece)
+	}
+
+	offset := int64(piece)*l.pieceLength + int64(begin)
+	if offset+int64(n) > l.length {
+		return 0, fmt.Errorf("block begin=%d length=%d of piece #%d is out of range", begin, n, piece)
+	}
+	return offset, nil
+}
+
+func (l layout) numPieces() int {
+	if l.pieceLength <= 0 {
+		return 0
+	}
+	return int((l.length + l.pieceLength - 1) / l.pieceLength)
+}
+
+// pieceSet records the pieces marked complete.
+type pieceSet struct {
+	mu        sync.Mutex
+	completed []bool
+}
+
+func (s *pieceSet) mark(piece int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if piece < 0 || piece >= len(s.completed) {
+		return fmt.Errorf("piece #%d is out of range", piece)
+	}
+	s.completed[piece] = true
+	return nil
+}
+
+// Completed reports whether piece was marked complete.
+func (s *pieceSet) Completed(piece int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return piece >= 0 && piece < len(s.completed) && s.completed[piece]
+}
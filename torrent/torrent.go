@@ -1,11 +1,102 @@
 package torrent
 
-import "bitTorrentClient/peers"
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"bitTorrentClient/peers"
+)
+
+// Priority is how much a file, or a piece, is wanted.
+type Priority int
+
+const (
+	PrioritySkip Priority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PrioritySkip:
+		return "skip"
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ParsePriority reads a priority by the name String gives it.
+func ParsePriority(s string) (Priority, error) {
+	for p := PrioritySkip; p <= PriorityHigh; p++ {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", s)
+}
 
 type PieceWork struct {
-	Index  int
-	Hash   [20]byte
-	Length int
+	Index    int
+	Hash     [20]byte
+	Length   int
+	Priority Priority
+}
+
+// WorkQueue hands out the pieces to download, highest priority first. It
+// keeps one channel per priority, so a piece put back goes ahead of every
+// piece of a lower priority rather than to the end of the queue.
+type WorkQueue struct {
+	queues [PriorityHigh + 1]chan *PieceWork
+}
+
+// NewWorkQueue queues works, keeping their order within each priority. Each
+// channel can hold all the pieces of its priority, so Put never blocks.
+func NewWorkQueue(works []*PieceWork) *WorkQueue {
+	var counts [PriorityHigh + 1]int
+	for _, work := range works {
+		counts[work.Priority]++
+	}
+
+	q := &WorkQueue{}
+	for i := range q.queues {
+		q.queues[i] = make(chan *PieceWork, counts[i])
+	}
+	for _, work := range works {
+		q.Put(work)
+	}
+	return q
+}
+
+// Put queues a piece, such as one taken earlier that was not downloaded.
+func (q *WorkQueue) Put(work *PieceWork) {
+	q.queues[work.Priority] <- work
+}
+
+// Take returns the next piece of the highest priority without waiting, or
+// false if there is none.
+func (q *WorkQueue) Take() (*PieceWork, bool) {
+	for i := len(q.queues) - 1; i >= 0; i-- {
+		select {
+		case work := <-q.queues[i]:
+			return work, true
+		default:
+		}
+	}
+	return nil, false
+}
+
+// Stats are the transfer counters of a torrent, shared by all of its peer
+// connections and reported to trackers.
+type Stats struct {
+	Uploaded   atomic.Int64
+	Downloaded atomic.Int64
 }
 
 type Torrent struct {
@@ -17,7 +108,7 @@ type Torrent struct {
 	TotalLength int
 	Name        string
 	// This is the most important part:
-	WorkQueue chan *PieceWork // A channel to hand out work to workers
+	WorkQueue *WorkQueue // Hands out work to workers
 }
 
 func New(peerId [20]byte, infoHash [20]byte, pieceLenght int, totalLength int, peers []peers.Peer, name string, pieceHashes [][20]byte) *Torrent {
@@ -31,3 +122,167 @@ func New(peerId [20]byte, infoHash [20]byte, pieceLenght int, totalLength int, p
 		PieceHashes: pieceHashes,
 	}
 }
+
+// Progress records which pieces of a torrent have been verified. It is shared
+// by all of the torrent's peer connections.
+type Progress struct {
+	mu       sync.RWMutex
+	bitfield []byte
+	skipped  []byte
+	count    int
+	total    int
+	// done counts the pieces verified or skipped
+	done     int
+	complete chan struct{}
+	// subscribers are signalled when pieces are verified
+	subscribers []chan struct{}
+}
+
+func NewProgress(numPieces int) *Progress {
+	p := &Progress{
+		bitfield: make([]byte, (numPieces+7)/8),
+		skipped:  make([]byte, (numPieces+7)/8),
+		total:    numPieces,
+		complete: make(chan struct{}),
+	}
+	if numPieces == 0 {
+		close(p.complete)
+	}
+	return p
+}
+
+// Skip marks a piece as not wanted, so that Complete does not wait for it.
+func (p *Progress) Skip(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if index < 0 || index >= p.total {
+		return
+	}
+
+	mask := byte(1 << (7 - index%8))
+	if p.skipped[index/8]&mask != 0 {
+		return
+	}
+	p.skipped[index/8] |= mask
+	if p.bitfield[index/8]&mask == 0 {
+		p.markDone()
+	}
+}
+
+// markDone counts one more piece as done. p.mu must be held.
+func (p *Progress) markDone() {
+	p.done++
+	if p.done == p.total {
+		close(p.complete)
+	}
+}
+
+// Set marks a piece as verified and reports whether it was not already.
+func (p *Progress) Set(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if index < 0 || index >= p.total {
+		return false
+	}
+
+	mask := byte(1 << (7 - index%8))
+	if p.bitfield[index/8]&mask != 0 {
+		return false
+	}
+	p.bitfield[index/8] |= mask
+	p.count++
+
+	for _, ch := range p.subscribers {
+		// a signal still pending covers this piece too
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	if p.skipped[index/8]&mask == 0 {
+		p.markDone()
+	}
+	return true
+}
+
+// Skipped reports whether a piece was marked as not wanted with Skip.
+func (p *Progress) Skipped(index int) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if index < 0 || index >= p.total {
+		return false
+	}
+	return p.skipped[index/8]>>(7-index%8)&1 != 0
+}
+
+func (p *Progress) Has(index int) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if index < 0 || index >= p.total {
+		return false
+	}
+	return p.bitfield[index/8]>>(7-index%8)&1 != 0
+}
+
+// Bitfield returns a copy of the verified pieces in the wire format of
+// MsgBitfield.
+func (p *Progress) Bitfield() []byte {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]byte(nil), p.bitfield...)
+}
+
+// Count returns the number of verified pieces.
+func (p *Progress) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.count
+}
+
+func (p *Progress) Len() int {
+	return p.total
+}
+
+// Subscribe returns a channel that is signalled when pieces are verified from
+// now on, such as to announce them to peers, and a function to unsubscribe.
+// Signals coalesce, so the subscriber compares Bitfield with the one it saw
+// last to tell which pieces are new.
+func (p *Progress) Subscribe() (<-chan struct{}, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	p.subscribers = append(p.subscribers, ch)
+
+	unsubscribe := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, sub := range p.subscribers {
+			if sub == ch {
+				p.subscribers = append(p.subscribers[:i], p.subscribers[i+1:]...)
+				break
+			}
+		}
+	}
+	return ch, unsubscribe
+}
+
+// Complete is closed once every piece not skipped is verified.
+func (p *Progress) Complete() <-chan struct{} {
+	return p.complete
+}
+
+// Done reports whether Complete is closed.
+func (p *Progress) Done() bool {
+	select {
+	case <-p.complete:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,394 @@
+package torrentFile
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"bitTorrentClient/bencode"
+)
+
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+
+	// targetPieces is roughly how many pieces an automatically chosen piece
+	// length aims for: enough for good swarm behaviour, few enough to keep
+	// the pieces string small.
+	targetPieces = 1500
+)
+
+type CreateOptions struct {
+	// PieceLength must be a power of two; 0 picks one from the total size.
+	PieceLength int64
+	// AnnounceList holds tiers of tracker URLs. The first URL also becomes
+	// the announce key.
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	// CreationDate defaults to now; set NoCreationDate to leave it out.
+	CreationDate   time.Time
+	NoCreationDate bool
+	Private        bool
+	WebSeeds       []string
+	// Workers is the number of pieces hashed concurrently, 0 means one per CPU.
+	Workers int
+}
+
+// sourceFile is a file being added to a new torrent.
+type sourceFile struct {
+	diskPath string
+	length   int64
+	path     []string
+}
+
+// Create builds a torrent for the file or directory at path. The returned
+// TorrentFile has InfoBytes set, so GetInfoHash works before it is saved.
+func Create(path string, opts CreateOptions) (*TorrentFile, error) {
+	// the torrent is named after the last path element, which "." or ".."
+	// only have once the path is absolute
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		return nil, fmt.Errorf("cannot name a torrent after %s", path)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []sourceFile
+	if stat.IsDir() {
+		files, err = collectFiles(path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		files = []sourceFile{{diskPath: path, length: stat.Size()}}
+	}
+
+	var totalLength int64
+	for _, file := range files {
+		totalLength += file.length
+	}
+	if totalLength == 0 {
+		return nil, fmt.Errorf("cannot create a torrent from empty content at %s", path)
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(totalLength)
+	}
+	if pieceLength < minPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length %d must be a power of two of at least %d", pieceLength, minPieceLength)
+	}
+
+	pieces, err := hashPieces(files, pieceLength, totalLength, opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+
+	tf := &TorrentFile{
+		Info: Info{
+			Name:        name,
+			PieceLength: pieceLength,
+			Pieces:      pieces,
+		},
+		Comment:   opts.Comment,
+		CreatedBy: opts.CreatedBy,
+	}
+
+	if stat.IsDir() {
+		for _, file := range files {
+			tf.Info.Files = append(tf.Info.Files, FileInfo{Length: file.length, Path: file.path})
+		}
+	} else {
+		tf.Info.Length = totalLength
+	}
+
+	if opts.Private {
+		tf.Info.Private = 1
+	}
+
+	for _, tier := range opts.AnnounceList {
+		if len(tier) == 0 {
+			continue
+		}
+		if tf.Announce == "" {
+			tf.Announce = tier[0]
+		}
+		tf.AnnounceList = append(tf.AnnounceList, tier)
+	}
+	// announce-list is only needed when there is more than the one tracker
+	if len(tf.AnnounceList) == 1 && len(tf.AnnounceList[0]) == 1 {
+		tf.AnnounceList = nil
+	}
+
+	if !opts.NoCreationDate {
+		date := opts.CreationDate
+		if date.IsZero() {
+			date = time.Now()
+		}
+		tf.CreationDate = date.Unix()
+	}
+
+	if len(opts.WebSeeds) > 0 {
+		var urls []interface{}
+		for _, seed := range opts.WebSeeds {
+			urls = append(urls, seed)
+		}
+		tf.URLList = urls
+	}
+
+	tf.InfoBytes, err = bencode.Marshal(tf.Info)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding the info dictionary: %v", err)
+	}
+
+	return tf, nil
+}
+
+// Write encodes the torrent as a .torrent file to w.
+func (tf *TorrentFile) Write(w io.Writer) error {
+	data, err := bencode.Marshal(tf)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Save writes the torrent to a .torrent file at path.
+func (tf *TorrentFile) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = tf.Write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// collectFiles lists the regular files below root in lexical order, which is
+// the order they take in the torrent.
+func collectFiles(root string) ([]sourceFile, error) {
+	var files []sourceFile
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, sourceFile{
+			diskPath: path,
+			length:   info.Size(),
+			path:     strings.Split(filepath.ToSlash(rel), "/"),
+		})
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found in %s", root)
+	}
+	return files, nil
+}
+
+func choosePieceLength(totalLength int64) int64 {
+	pieceLength := int64(minPieceLength)
+	for pieceLength < maxPieceLength && totalLength/pieceLength > targetPieces {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces computes the concatenated SHA-1 hashes of every piece of the
+// files, hashing up to workers pieces at once.
+func hashPieces(files []sourceFile, pieceLength, totalLength int64, workers int) ([]byte, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	numPieces := int((totalLength + pieceLength - 1) / pieceLength)
+	pieces := make([]byte, numPieces*sha1.Size)
+
+	source := newSourceReader(files, pieceLength)
+	defer source.close()
+
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, pieceLength)
+
+			for index := range indexes {
+				begin := int64(index) * pieceLength
+				length := min(pieceLength, totalLength-begin)
+
+				err := source.readAt(buf[:length], begin)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				hash := sha1.Sum(buf[:length])
+				copy(pieces[index*sha1.Size:], hash[:])
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for index := 0; index < numPieces; index++ {
+		select {
+		case indexes <- index:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err == nil && len(errs) > 0 {
+		err = <-errs
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while hashing pieces: %v", err)
+	}
+	return pieces, nil
+}
+
+// sourceReader reads the pieces of a new torrent from its files. Each file is
+// opened once and closed after the last piece that covers it was read, so a
+// torrent of many files does not keep them all open.
+type sourceReader struct {
+	files []sourceFile
+
+	mu      sync.Mutex
+	handles []*os.File
+	// pieces is how many pieces of each file are still to be read
+	pieces []int64
+}
+
+func newSourceReader(files []sourceFile, pieceLength int64) *sourceReader {
+	r := &sourceReader{
+		files:   files,
+		handles: make([]*os.File, len(files)),
+		pieces:  make([]int64, len(files)),
+	}
+
+	var fileStart int64
+	for i, file := range files {
+		if file.length > 0 {
+			first := fileStart / pieceLength
+			last := (fileStart + file.length - 1) / pieceLength
+			r.pieces[i] = last - first + 1
+		}
+		fileStart += file.length
+	}
+	return r
+}
+
+// readAt fills buf with the data starting at offset in the concatenation of
+// the files. buf must be one whole piece.
+func (r *sourceReader) readAt(buf []byte, offset int64) error {
+	var fileStart int64
+	for i, file := range r.files {
+		fileEnd := fileStart + file.length
+		if len(buf) == 0 {
+			return nil
+		}
+		if offset >= fileEnd {
+			fileStart = fileEnd
+			continue
+		}
+
+		n := min(int64(len(buf)), fileEnd-offset)
+		err := r.readFileAt(i, buf[:n], offset-fileStart)
+		if err != nil {
+			return err
+		}
+
+		buf = buf[n:]
+		offset += n
+		fileStart = fileEnd
+	}
+
+	if len(buf) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func (r *sourceReader) readFileAt(index int, buf []byte, offset int64) error {
+	r.mu.Lock()
+	file := r.handles[index]
+	if file == nil {
+		var err error
+		file, err = os.Open(r.files[index].diskPath)
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		r.handles[index] = file
+	}
+	r.mu.Unlock()
+
+	_, err := file.ReadAt(buf, offset)
+
+	r.mu.Lock()
+	r.pieces[index]--
+	if r.pieces[index] == 0 {
+		file.Close()
+		r.handles[index] = nil
+	}
+	r.mu.Unlock()
+	return err
+}
+
+// close closes the files left open when hashing stopped early.
+func (r *sourceReader) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, file := range r.handles {
+		if file != nil {
+			file.Close()
+			r.handles[i] = nil
+		}
+	}
+}
@@ -1,14 +1,11 @@
 package torrentFile
 
 import (
-	"bytes"
-	"crypto/rand"
 	"crypto/sha1"
 	"fmt"
-	"net/url"
+	"net"
 	"os"
 	"strconv"
-	"strings"
 
 	"bitTorrentClient/bencode"
 	"bitTorrentClient/torrent"
@@ -16,11 +13,12 @@ import (
 
 // In torrentfile.go
 type Info struct {
-	PieceLength int64      `bencode:"piece length" json:"piece length"`
-	Pieces      string     `bencode:"pieces"       json:"pieces"`
-	Name        string     `bencode:"name"         json:"name"`
-	Length      int64      `bencode:"length"       json:"length,omitempty"` // omitempty is good practice
-	Files       []FileInfo `bencode:"files"        json:"files,omitempty"`
+	PieceLength int64      `bencode:"piece length"      json:"piece length"`
+	Pieces      []byte     `bencode:"pieces"            json:"pieces"`
+	Name        string     `bencode:"name"              json:"name"`
+	Length      int64      `bencode:"length,omitempty"  json:"length,omitempty"` // omitempty is good practice
+	Files       []FileInfo `bencode:"files,omitempty"   json:"files,omitempty"`
+	Private     int64      `bencode:"private,omitempty" json:"private,omitempty"`
 }
 
 type FileInfo struct {
@@ -29,140 +27,138 @@ type FileInfo struct {
 }
 
 type TorrentFile struct {
-	Announce     string     `bencode:"announce"       json:"announce"`
-	Info         Info       `bencode:"info"           json:"info"`
-	AnnounceList [][]string `bencode:"announce-list"  json:"announce-list"`
-	CreationDate int64      `bencode:"creation date"  json:"creation date"`
-	Comment      string     `bencode:"comment"        json:"comment"`
-	CreatedBy    string     `bencode:"created by"     json:"created by"`
-	Encoding     string     `bencode:"encoding"       json:"encoding"`
-	InfoBytes    []byte     `bencode:"infoBytes"	  json:"infoBytes"`
-	PeerId       []byte     `bencode:"peerId" json:"peerId`
+	Announce     string      `bencode:"announce,omitempty"      json:"announce"`
+	Info         Info        `bencode:"info"                    json:"info"`
+	AnnounceList [][]string  `bencode:"announce-list,omitempty" json:"announce-list"`
+	CreationDate int64       `bencode:"creation date,omitempty" json:"creation date"`
+	Comment      string      `bencode:"comment,omitempty"       json:"comment"`
+	CreatedBy    string      `bencode:"created by,omitempty"    json:"created by"`
+	Encoding     string      `bencode:"encoding,omitempty"      json:"encoding"`
+	URLList      interface{} `bencode:"url-list,omitempty"      json:"url-list,omitempty"` // a single string or a list of strings
+	Nodes        interface{} `bencode:"nodes,omitempty"         json:"nodes,omitempty"`    // DHT nodes as [host, port] pairs
+	InfoBytes    []byte      `bencode:"-"                       json:"infoBytes"`
+	PeerId       []byte      `bencode:"-"                       json:"peerId"`
+}
+
+// metainfo is a torrent file as read by Open. The info dictionary is kept
+// raw, and the optional keys are checked by hand so that a key of the wrong
+// type is ignored instead of failing the whole file.
+type metainfo struct {
+	Announce     interface{}        `bencode:"announce"`
+	Info         bencode.RawMessage `bencode:"info"`
+	AnnounceList interface{}        `bencode:"announce-list"`
+	CreationDate interface{}        `bencode:"creation date"`
+	Comment      interface{}        `bencode:"comment"`
+	CreatedBy    interface{}        `bencode:"created by"`
+	Encoding     interface{}        `bencode:"encoding"`
+	URLList      interface{}        `bencode:"url-list"`
+	Nodes        interface{}        `bencode:"nodes"`
 }
 
 func Open(path string) (*TorrentFile, error) {
-	fileReader, err := os.Open(path)
+	data, err := os.ReadFile(path)
 
 	if err != nil {
 		return nil, fmt.Errorf("error while reading a file %v", err)
 	}
 
-	decodedData, err := bencode.Decode(fileReader)
-
-	infoMap, ok := decodedData.(map[string]interface{})["info"]
-
-	if !ok {
-		return nil, fmt.Errorf("error while asserting info map")
-	}
-
-	var infoBuf bytes.Buffer
-	err = bencode.Encode(&infoBuf, infoMap)
+	var meta metainfo
+	err = bencode.Unmarshal(data, &meta)
 
 	if err != nil {
 		return nil, fmt.Errorf("error while decoding the data: %v", err)
 	}
 
-	res, err := toTorrentFile(decodedData.(map[string]interface{}))
+	// the info-hash must be computed over the info dictionary exactly as it
+	// appears in the file, so keep its raw bytes rather than re-encoding it
+	if len(meta.Info) == 0 || meta.Info[0] != 'd' {
+		return nil, fmt.Errorf("missing or invalid 'info' dictionary in torrent file")
+	}
+
+	res := TorrentFile{
+		AnnounceList: announceList(meta.AnnounceList),
+		URLList:      meta.URLList,
+		Nodes:        meta.Nodes,
+		InfoBytes:    meta.Info,
+	}
+	err = bencode.Unmarshal(meta.Info, &res.Info)
 
 	if err != nil {
 		return nil, fmt.Errorf("error while populating torrentfile %v", err)
 	}
 
-	res.InfoBytes = infoBuf.Bytes()
-
-	return res, nil
-}
-
-// toTorrentFile manually maps a decoded bencode map to a TorrentFile struct.
-// This is a robust way to create the struct without data corruption from other formats like JSON.
-func toTorrentFile(data map[string]interface{}) (*TorrentFile, error) {
-	var tf TorrentFile
-
-	// --- 1. Populate top-level string and integer fields ---
-	// We use safe type assertions. If a key doesn't exist or has the wrong type,
-	// the field will be left as its zero value (e.g., empty string or 0).
-	if announce, ok := data["announce"].(string); ok {
-		tf.Announce = announce
+	// We use safe type assertions. If a key has the wrong type, the field is
+	// left as its zero value.
+	if announce, ok := meta.Announce.(string); ok {
+		res.Announce = announce
 	}
-	if comment, ok := data["comment"].(string); ok {
-		tf.Comment = comment
+	if creationDate, ok := meta.CreationDate.(int64); ok {
+		res.CreationDate = creationDate
 	}
-	if createdBy, ok := data["created by"].(string); ok {
-		tf.CreatedBy = createdBy
+	if comment, ok := meta.Comment.(string); ok {
+		res.Comment = comment
 	}
-	if creationDate, ok := data["creation date"].(int64); ok {
-		tf.CreationDate = creationDate
+	if createdBy, ok := meta.CreatedBy.(string); ok {
+		res.CreatedBy = createdBy
 	}
-	if encoding, ok := data["encoding"].(string); ok {
-		tf.Encoding = encoding
+	if encoding, ok := meta.Encoding.(string); ok {
+		res.Encoding = encoding
 	}
 
-	// --- 2. Populate the nested 'info' dictionary ---
-	infoMap, ok := data["info"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("missing or invalid 'info' dictionary in torrent file")
-	}
-
-	if name, ok := infoMap["name"].(string); ok {
-		tf.Info.Name = name
-	}
-	if pl, ok := infoMap["piece length"].(int64); ok {
-		tf.Info.PieceLength = pl
-	}
-	// This is the most critical part: get the raw, uncorrupted binary string
-	if pieces, ok := infoMap["pieces"].(string); ok {
-		tf.Info.Pieces = pieces
-	}
+	return &res, nil
+}
 
-	// Handle both single-file and multi-file torrents
-	if length, ok := infoMap["length"].(int64); ok {
-		tf.Info.Length = length // Single-file torrent
+// announceList reads the tiers of an announce-list, skipping anything that
+// is not a list of tracker URLs.
+func announceList(value interface{}) [][]string {
+	tiers, ok := value.([]interface{})
+	if !ok {
+		return nil
 	}
 
-	if filesData, ok := infoMap["files"].([]interface{}); ok {
-		// Multi-file torrent, loop through the files list
-		for _, fileData := range filesData {
-			fileMap, ok := fileData.(map[string]interface{})
-			if !ok {
-				continue // Skip malformed entries
-			}
-
-			var fileInfo FileInfo
-			if l, ok := fileMap["length"].(int64); ok {
-				fileInfo.Length = l
-			}
-
-			// The 'path' is a list of interfaces, where each should be a string
-			if pathData, ok := fileMap["path"].([]interface{}); ok {
-				for _, pathElement := range pathData {
-					if pathStr, ok := pathElement.(string); ok {
-						fileInfo.Path = append(fileInfo.Path, pathStr)
-					}
-				}
+	var res [][]string
+	for _, item := range tiers {
+		tier, ok := item.([]interface{})
+		if !ok {
+			continue
+		}
+		var urls []string
+		for _, tracker := range tier {
+			if url, ok := tracker.(string); ok {
+				urls = append(urls, url)
 			}
-			tf.Info.Files = append(tf.Info.Files, fileInfo)
+		}
+		if len(urls) > 0 {
+			res = append(res, urls)
 		}
 	}
+	return res
+}
 
-	// --- 3. Populate the 'announce-list' (list of lists of strings) ---
-	if announceList, ok := data["announce-list"].([]interface{}); ok {
-		for _, tierData := range announceList {
-			tier, ok := tierData.([]interface{})
-			if !ok {
-				continue // Skip malformed tiers
-			}
+// FromMetadata builds a TorrentFile from a raw info dictionary, such as one
+// fetched from peers with ut_metadata, and the trackers of the magnet link it
+// came from. Each tracker gets its own tier.
+func FromMetadata(infoBytes []byte, trackers []string) (*TorrentFile, error) {
+	var res TorrentFile
+	err := bencode.Unmarshal(infoBytes, &res.Info)
 
-			var tierList []string
-			for _, trackerData := range tier {
-				if tracker, ok := trackerData.(string); ok {
-					tierList = append(tierList, tracker)
-				}
-			}
-			tf.AnnounceList = append(tf.AnnounceList, tierList)
+	if err != nil {
+		return nil, fmt.Errorf("error while decoding the info dictionary: %v", err)
+	}
+
+	for _, tracker := range trackers {
+		if res.Announce == "" {
+			res.Announce = tracker
 		}
+		res.AnnounceList = append(res.AnnounceList, []string{tracker})
+	}
+	if len(res.AnnounceList) == 1 {
+		res.AnnounceList = nil
 	}
 
-	return &tf, nil
+	res.InfoBytes = infoBytes
+
+	return &res, nil
 }
 
 func (tf *TorrentFile) GetInfoHash() ([]byte, error) {
@@ -171,62 +167,141 @@ func (tf *TorrentFile) GetInfoHash() ([]byte, error) {
 	return shaSum[:], nil
 }
 
-func (tf *TorrentFile) BuildTrackerUrl(infoHash [20]byte) (string, error) {
-	params := url.Values{}
-	params.Add("info_hash", string(infoHash[:]))
+// Trackers lists every tracker URL of the torrent once, the announce key
+// first and then the announce-list tiers in order.
+func (tf *TorrentFile) Trackers() []string {
+	var res []string
+	seen := make(map[string]bool)
 
-	peerId := make([]byte, 20)
-
-	_, err := rand.Read(peerId)
-
-	if err != nil {
-		return "", err
+	add := func(tracker string) {
+		if tracker != "" && !seen[tracker] {
+			seen[tracker] = true
+			res = append(res, tracker)
+		}
 	}
 
-	tf.PeerId = peerId
+	add(tf.Announce)
+	for _, tier := range tf.AnnounceList {
+		for _, tracker := range tier {
+			add(tracker)
+		}
+	}
 
-	var left int
+	return res
+}
 
-	for _, item := range tf.Info.Files {
-		left += int(item.Length)
+// AnnounceTiers returns the tracker tiers of the torrent (BEP 12). When an
+// announce-list is present the announce key is ignored, as the BEP asks.
+func (tf *TorrentFile) AnnounceTiers() [][]string {
+	var res [][]string
+	for _, tier := range tf.AnnounceList {
+		var urls []string
+		for _, tracker := range tier {
+			if tracker != "" {
+				urls = append(urls, tracker)
+			}
+		}
+		if len(urls) > 0 {
+			res = append(res, urls)
+		}
 	}
 
-	if left == 0 {
-		left += int(tf.Info.Length)
+	if len(res) == 0 && tf.Announce != "" {
+		res = [][]string{{tf.Announce}}
 	}
+	return res
+}
 
-	params.Add("peer_id", string(peerId[:]))
-	params.Add("port", "6881")
-	params.Add("uploaded", "0")
-	params.Add("downloaded", "0")
-	params.Add("left", strconv.Itoa(left))
+// IsPrivate reports whether the torrent sets the private flag (BEP 27).
+func (tf *TorrentFile) IsPrivate() bool {
+	return tf.Info.Private == 1
+}
 
-	var announce string
+// WebSeeds returns the GetRight style web seed URLs (BEP 19). The url-list key
+// may hold a single string or a list of strings.
+func (tf *TorrentFile) WebSeeds() []string {
+	switch urls := tf.URLList.(type) {
+	case string:
+		if urls == "" {
+			return nil
+		}
+		return []string{urls}
+	case []interface{}:
+		var res []string
+		for _, item := range urls {
+			if url, ok := item.(string); ok && url != "" {
+				res = append(res, url)
+			}
+		}
+		return res
+	}
+	return nil
+}
 
-	if strings.HasPrefix(tf.Announce, "udp") {
-		announce = tf.getAnnounceUrl()
-	} else {
-		announce = tf.Announce
+// DHTNodes returns the DHT nodes of a trackerless torrent (BEP 5) as
+// host:port addresses.
+func (tf *TorrentFile) DHTNodes() []string {
+	list, ok := tf.Nodes.([]interface{})
+	if !ok {
+		return nil
 	}
 
-	trackerUrl := fmt.Sprintf("%s?%s", announce, params.Encode())
+	var res []string
+	for _, item := range list {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		host, hostOk := pair[0].(string)
+		port, portOk := pair[1].(int64)
+		if !hostOk || !portOk || host == "" || port <= 0 || port > 65535 {
+			continue
+		}
+		res = append(res, net.JoinHostPort(host, strconv.FormatInt(port, 10)))
+	}
+	return res
+}
 
-	return trackerUrl, nil
+// FileEntry is one file of the torrent along with where it sits in the
+// concatenated piece data.
+type FileEntry struct {
+	Path       []string // starts with Info.Name
+	Length     int64
+	Offset     int64
+	FirstPiece int
+	LastPiece  int // FirstPiece-1 for empty files, which cover no piece
 }
 
-func (tf *TorrentFile) getAnnounceUrl() string {
+// FileEntries lists the files of the torrent in piece order. A single-file
+// torrent yields one entry named after Info.Name.
+func (tf *TorrentFile) FileEntries() []FileEntry {
+	files := tf.Info.Files
+	if len(files) == 0 {
+		files = []FileInfo{{Length: tf.Info.Length}}
+	}
 
-	for _, tier := range tf.AnnounceList {
-		for _, item := range tier {
-			if strings.HasPrefix(item, "http") {
-				fmt.Println("announce: ", item)
-				return item
+	var res []FileEntry
+	var offset int64
+	for _, file := range files {
+		entry := FileEntry{
+			Path:   append([]string{tf.Info.Name}, file.Path...),
+			Length: file.Length,
+			Offset: offset,
+		}
+
+		if tf.Info.PieceLength > 0 {
+			entry.FirstPiece = int(offset / tf.Info.PieceLength)
+			entry.LastPiece = entry.FirstPiece - 1
+			if file.Length > 0 {
+				entry.LastPiece = int((offset + file.Length - 1) / tf.Info.PieceLength)
 			}
 		}
+
+		res = append(res, entry)
+		offset += file.Length
 	}
 
-	fmt.Println("there is no http announce url")
-	return ""
+	return res
 }
 
 func (tf *TorrentFile) CalculateSize() int {
@@ -256,9 +331,7 @@ func (tf *TorrentFile) GetPieceHashes() [][20]byte {
 	for i := 0; i < numTimes; i++ {
 		var hash [20]byte
 
-		hashSlice := []byte(tf.Info.Pieces[i*20 : (i+1)*20]) // (i+1)*20 is a bit safer
-
-		copy(hash[:], hashSlice)
+		copy(hash[:], tf.Info.Pieces[i*20:(i+1)*20])
 
 		res = append(res, hash)
 	}
@@ -266,9 +339,39 @@ func (tf *TorrentFile) GetPieceHashes() [][20]byte {
 	return res
 }
 
-func (tf *TorrentFile) CreateWorkQueue(pieceHashes [][20]byte, totalSize int) chan *torrent.PieceWork {
-	pieceWorks := make([]*torrent.PieceWork, len(pieceHashes))
+// PiecePriorities maps the priority of each file, in FileEntries order, to
+// its pieces. A piece shared by several files gets the highest of their
+// priorities, so the boundary pieces of a wanted file are fetched too.
+func (tf *TorrentFile) PiecePriorities(filePriorities []torrent.Priority) []torrent.Priority {
+	res := make([]torrent.Priority, len(tf.GetPieceHashes()))
+	for i, entry := range tf.FileEntries() {
+		if i >= len(filePriorities) {
+			break
+		}
+		for piece := entry.FirstPiece; piece <= entry.LastPiece && piece < len(res); piece++ {
+			res[piece] = max(res[piece], filePriorities[i])
+		}
+	}
+	return res
+}
+
+// CreateWorkQueue queues every piece that have does not hold yet; have may be
+// nil. With priorities, pieces are handed out highest priority first and
+// skipped ones are left out; nil priorities queue every piece in order.
+func (tf *TorrentFile) CreateWorkQueue(pieceHashes [][20]byte, totalSize int, have *torrent.Progress, priorities []torrent.Priority) *torrent.WorkQueue {
+	var pieceWorks []*torrent.PieceWork
 	for i, hash := range pieceHashes {
+		if have != nil && have.Has(i) {
+			continue
+		}
+		priority := torrent.PriorityNormal
+		if priorities != nil {
+			if i >= len(priorities) || priorities[i] == torrent.PrioritySkip {
+				continue
+			}
+			priority = priorities[i]
+		}
+
 		// Calculate the length of this specific piece
 		begin := i * int(tf.Info.PieceLength)
 		end := begin + int(tf.Info.PieceLength)
@@ -277,18 +380,13 @@ func (tf *TorrentFile) CreateWorkQueue(pieceHashes [][20]byte, totalSize int) ch
 		}
 		length := end - begin
 
-		pieceWorks[i] = &torrent.PieceWork{
-			Index:  i,
-			Hash:   hash,
-			Length: length,
-		}
-	}
-
-	workQueue := make(chan *torrent.PieceWork, len(pieceHashes))
-
-	for _, work := range pieceWorks {
-		workQueue <- work
+		pieceWorks = append(pieceWorks, &torrent.PieceWork{
+			Index:    i,
+			Hash:     hash,
+			Length:   length,
+			Priority: priority,
+		})
 	}
 
-	return workQueue
+	return torrent.NewWorkQueue(pieceWorks)
 }
@@ -0,0 +1,94 @@
+package torrentFile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"bitTorrentClient/bencode"
+)
+
+func writeTorrent(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.torrent")
+	err := os.WriteFile(path, []byte(data), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testInfo = "d6:lengthi5e4:name1:a12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+
+func TestOpenIgnoresMistypedKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		announce string
+		tiers    [][]string
+	}{
+		{"creation date as a string", "d8:announce3:u:113:creation date5:today4:info" + testInfo + "e", "u:1", nil},
+		{"announce-list not a list", "d8:announce3:u:113:announce-list3:u:24:info" + testInfo + "e", "u:1", nil},
+		{"announce-list with bad tiers", "d13:announce-listl3:u:2l3:u:3i1eee4:info" + testInfo + "e", "", [][]string{{"u:3"}}},
+		{"announce as an integer", "d8:announcei1e7:comment3:hey4:info" + testInfo + "e", "", nil},
+	}
+
+	for _, test := range tests {
+		tf, err := Open(writeTorrent(t, test.data))
+		if err != nil {
+			t.Errorf("%s: Open failed: %v", test.name, err)
+			continue
+		}
+		if tf.Announce != test.announce || !reflect.DeepEqual(tf.AnnounceList, test.tiers) {
+			t.Errorf("%s: got announce %q and tiers %q", test.name, tf.Announce, tf.AnnounceList)
+		}
+		if tf.Info.Name != "a" || tf.Info.Length != 5 || string(tf.InfoBytes) != testInfo {
+			t.Errorf("%s: got info %+v", test.name, tf.Info)
+		}
+	}
+
+	invalid := []string{
+		"d8:announce3:u:1e",
+		"d4:infoi1ee",
+		"d4:infod4:name1:a12:piece length3:bige",
+	}
+	for _, data := range invalid {
+		if _, err := Open(writeTorrent(t, data)); err == nil {
+			t.Errorf("Open(%q) succeeded", data)
+		}
+	}
+}
+
+func TestInfoHashOverRawBytes(t *testing.T) {
+	// unsorted keys, an integer with a leading zero and an unknown key: a
+	// re-encoding would change every one of them
+	info := "d4:name1:a6:lengthi05e12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaa1:xl1:ye0:0:e"
+	tf, err := Open(writeTorrent(t, "d8:announce3:u:14:info"+info+"7:comment3:hiye"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(tf.InfoBytes) != info {
+		t.Errorf("got info bytes %q, want %q", tf.InfoBytes, info)
+	}
+	hash, _ := tf.GetInfoHash()
+	want := sha1.Sum([]byte(info))
+	if !bytes.Equal(hash, want[:]) {
+		t.Errorf("got info-hash %x, want %x", hash, want)
+	}
+
+	reencoded, err := bencode.Marshal(tf.Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other := sha1.Sum(reencoded); bytes.Equal(hash, other[:]) {
+		t.Error("info-hash matches the re-encoded info dictionary")
+	}
+	if tf.Info.Name != "a" || tf.Info.Length != 5 || tf.Comment != "hiy" {
+		t.Errorf("got info %+v and comment %q", tf.Info, tf.Comment)
+	}
+}
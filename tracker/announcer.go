@@ -0,0 +1,215 @@
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+const (
+	defaultInterval = 30 * time.Minute
+	defaultNumWant  = 50
+
+	// retryInterval is the first delay after a failed announce; it doubles
+	// on each further failure up to the tracker's interval.
+	retryInterval = 15 * time.Second
+
+	// completionCheck is how often Run looks at the counters to notice that
+	// the download finished.
+	completionCheck = time.Second
+
+	stoppedTimeout = 5 * time.Second
+)
+
+// Stats are the transfer counters reported to the tracker.
+type Stats struct {
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+}
+
+// Announcer drives the announce lifecycle of one torrent on one tracker:
+// started, periodic re-announces at the tracker's interval, completed once
+// nothing is left, and stopped on shutdown.
+type Announcer struct {
+	Tracker  Tracker
+	InfoHash [20]byte
+	PeerID   [20]byte
+	Port     uint16
+	NumWant  int
+	// IPv4 and IPv6 are our addresses to report besides the one the tracker
+	// sees the announce come from.
+	IPv4 net.IP
+	IPv6 net.IP
+
+	// Stats is called before every announce for the current counters.
+	Stats func() Stats
+	// OnPeers, if set, receives the peers of every successful announce.
+	OnPeers func([]peers.Peer)
+	// OnError, if set, receives failed announces as well as warnings
+	// (*WarningError) on successful ones.
+	OnError func(error)
+
+	key         uint32
+	trackerID   string
+	interval    time.Duration
+	minInterval time.Duration
+	started     bool
+	completed   bool
+}
+
+func NewAnnouncer(t Tracker, infoHash, peerId [20]byte, port uint16, stats func() Stats) *Announcer {
+	var key [4]byte
+	rand.Read(key[:])
+
+	return &Announcer{
+		Tracker:  t,
+		InfoHash: infoHash,
+		PeerID:   peerId,
+		Port:     port,
+		NumWant:  defaultNumWant,
+		Stats:    stats,
+		key:      binary.BigEndian.Uint32(key[:]),
+		interval: defaultInterval,
+	}
+}
+
+// Announce sends a single announce with the given event and remembers the
+// tracker id and intervals from the response.
+func (a *Announcer) Announce(ctx context.Context, event Event) (*AnnounceResponse, error) {
+	stats := a.Stats()
+
+	req := &AnnounceRequest{
+		InfoHash:   a.InfoHash,
+		PeerID:     a.PeerID,
+		Port:       a.Port,
+		Uploaded:   stats.Uploaded,
+		Downloaded: stats.Downloaded,
+		Left:       stats.Left,
+		Event:      event,
+		NumWant:    a.NumWant,
+		Key:        a.key,
+		TrackerID:  a.trackerID,
+		IPv4:       a.IPv4,
+		IPv6:       a.IPv6,
+	}
+	if event == EventStopped {
+		req.NumWant = 0
+	}
+
+	resp, err := a.Tracker.Announce(ctx, req)
+	if err != nil {
+		a.report(err)
+		return nil, err
+	}
+
+	if resp.Warning != "" {
+		a.report(&WarningError{URL: a.Tracker.URL(), Message: resp.Warning})
+	}
+	if resp.TrackerID != "" {
+		a.trackerID = resp.TrackerID
+	}
+	if resp.Interval > 0 {
+		a.interval = resp.Interval
+	}
+	a.minInterval = resp.MinInterval
+
+	switch event {
+	case EventStarted:
+		a.started = true
+	case EventCompleted:
+		a.completed = true
+	}
+
+	if a.OnPeers != nil && len(resp.Peers) > 0 {
+		a.OnPeers(resp.Peers)
+	}
+
+	return resp, nil
+}
+
+// Run announces until ctx is cancelled and then sends stopped, preceded by
+// completed if the download finished and that was not announced yet. If
+// Announce was not already called with EventStarted, Run starts with it.
+func (a *Announcer) Run(ctx context.Context) {
+	// a torrent that starts with nothing left is seeding and never completes
+	wasIncomplete := a.Stats().Left > 0
+
+	next := time.Duration(0)
+	if a.started {
+		next = a.nextAnnounce()
+	}
+	retry := retryInterval
+	completionQueued := false
+
+	timer := time.NewTimer(next)
+	defer timer.Stop()
+	check := time.NewTicker(completionCheck)
+	defer check.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if a.started {
+				stopCtx, cancel := context.WithTimeout(context.Background(), stoppedTimeout)
+				// a download that finished just before shutdown has not
+				// announced completed yet, and stopped alone would not tell
+				// the tracker
+				if wasIncomplete && !a.completed && a.Stats().Left == 0 {
+					a.Announce(stopCtx, EventCompleted)
+				}
+				a.Announce(stopCtx, EventStopped)
+				cancel()
+			}
+			return
+
+		case <-check.C:
+			// announce completed right away rather than at the next interval;
+			// if that fails it is retried with the regular announces
+			if completionQueued || !wasIncomplete || a.completed || !a.started || a.Stats().Left > 0 {
+				continue
+			}
+			completionQueued = true
+			timer.Reset(0)
+
+		case <-timer.C:
+			event := EventNone
+			if !a.started {
+				event = EventStarted
+			} else if completionQueued && !a.completed {
+				event = EventCompleted
+			}
+
+			_, err := a.Announce(ctx, event)
+			if err != nil {
+				var failure *FailureError
+				if errors.As(err, &failure) {
+					retry = max(retry, a.nextAnnounce())
+				}
+				timer.Reset(retry)
+				retry = min(retry*2, a.nextAnnounce())
+				continue
+			}
+
+			retry = retryInterval
+			timer.Reset(a.nextAnnounce())
+		}
+	}
+}
+
+// nextAnnounce is the delay until the next regular announce: the tracker's
+// interval, but never sooner than its min interval.
+func (a *Announcer) nextAnnounce() time.Duration {
+	return max(a.interval, a.minInterval)
+}
+
+func (a *Announcer) report(err error) {
+	if a.OnError != nil {
+		a.OnError(err)
+	}
+}
@@ -0,0 +1,134 @@
+package tracker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+// eventRecorder is an HTTP tracker that records the event of every announce,
+// "" for regular ones. Every response carries one peer, so the announcer's
+// OnPeers tells when a response was handled.
+type eventRecorder struct {
+	mu        sync.Mutex
+	events    []string
+	processed chan struct{}
+}
+
+func newEventRecorder(t *testing.T) (*eventRecorder, *httptest.Server) {
+	t.Helper()
+
+	rec := &eventRecorder{processed: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.mu.Lock()
+		rec.events = append(rec.events, r.URL.Query().Get("event"))
+		rec.mu.Unlock()
+
+		w.Write([]byte("d8:intervali1800e5:peers6:\x0a\x00\x00\x01\x1a\xe1e"))
+	}))
+	t.Cleanup(srv.Close)
+	return rec, srv
+}
+
+// wait blocks until the announcer handled the response to an announce with
+// event.
+func (rec *eventRecorder) wait(t *testing.T, event string) {
+	t.Helper()
+
+	select {
+	case <-rec.processed:
+		events := rec.recorded()
+		if got := events[len(events)-1]; got != event {
+			t.Fatalf("got event %q, want %q", got, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no announce, want event %q", event)
+	}
+}
+
+func (rec *eventRecorder) recorded() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string(nil), rec.events...)
+}
+
+// runAnnouncer runs an announcer against srv with left bytes to download and
+// returns a function that stops it and waits for Run to return.
+func runAnnouncer(rec *eventRecorder, srv *httptest.Server, left *atomic.Int64) func() {
+	a := NewAnnouncer(NewHTTPTracker(srv.URL+"/announce"), [20]byte{1}, [20]byte{2}, 6881, func() Stats {
+		return Stats{Left: left.Load()}
+	})
+	a.OnPeers = func([]peers.Peer) {
+		rec.processed <- struct{}{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestAnnouncerCompletedBeforeStop(t *testing.T) {
+	rec, srv := newEventRecorder(t)
+
+	var left atomic.Int64
+	left.Store(1000)
+	stop := runAnnouncer(rec, srv, &left)
+	rec.wait(t, "started")
+
+	// the download finishes and the program exits right away, before Run
+	// notices on its own
+	left.Store(0)
+	stop()
+
+	want := []string{"started", "completed", "stopped"}
+	if got := rec.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestAnnouncerCompletedWhileRunning(t *testing.T) {
+	rec, srv := newEventRecorder(t)
+
+	var left atomic.Int64
+	left.Store(1000)
+	stop := runAnnouncer(rec, srv, &left)
+	rec.wait(t, "started")
+
+	left.Store(0)
+	rec.wait(t, "completed")
+	stop()
+
+	want := []string{"started", "completed", "stopped"}
+	if got := rec.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestAnnouncerSeeding(t *testing.T) {
+	rec, srv := newEventRecorder(t)
+
+	// a torrent that starts complete is seeded and never completes
+	var left atomic.Int64
+	stop := runAnnouncer(rec, srv, &left)
+	rec.wait(t, "started")
+	stop()
+
+	want := []string{"started", "stopped"}
+	if got := rec.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,210 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitTorrentClient/bencode"
+	"bitTorrentClient/peers"
+)
+
+// maxResponseSize bounds how much of a tracker response we read.
+const maxResponseSize = 4 << 20
+
+type HTTPTracker struct {
+	url    string
+	Client *http.Client
+}
+
+func NewHTTPTracker(announceURL string) *HTTPTracker {
+	return &HTTPTracker{
+		url:    announceURL,
+		Client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (t *HTTPTracker) URL() string {
+	return t.url
+}
+
+type httpAnnounceResponse struct {
+	FailureReason  string      `bencode:"failure reason"`
+	WarningMessage string      `bencode:"warning message"`
+	Interval       int64       `bencode:"interval"`
+	MinInterval    int64       `bencode:"min interval"`
+	TrackerID      string      `bencode:"tracker id"`
+	Complete       int64       `bencode:"complete"`
+	Incomplete     int64       `bencode:"incomplete"`
+	Peers          interface{} `bencode:"peers"`
+	Peers6         interface{} `bencode:"peers6"`
+}
+
+func (t *HTTPTracker) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	params := url.Values{}
+	params.Add("info_hash", string(req.InfoHash[:]))
+	params.Add("peer_id", string(req.PeerID[:]))
+	params.Add("port", strconv.Itoa(int(req.Port)))
+	params.Add("uploaded", strconv.FormatInt(req.Uploaded, 10))
+	params.Add("downloaded", strconv.FormatInt(req.Downloaded, 10))
+	params.Add("left", strconv.FormatInt(req.Left, 10))
+	params.Add("compact", "1")
+	params.Add("key", fmt.Sprintf("%08x", req.Key))
+	if req.Event != EventNone {
+		params.Add("event", string(req.Event))
+	}
+	if req.NumWant > 0 {
+		params.Add("numwant", strconv.Itoa(req.NumWant))
+	}
+	if req.TrackerID != "" {
+		params.Add("trackerid", req.TrackerID)
+	}
+	if ip4 := req.IPv4.To4(); ip4 != nil {
+		params.Add("ipv4", ip4.String())
+	}
+	if req.IPv6 != nil && req.IPv6.To4() == nil {
+		params.Add("ipv6", req.IPv6.String())
+	}
+
+	var raw httpAnnounceResponse
+	err := t.get(ctx, t.url, params, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.FailureReason != "" {
+		return nil, &FailureError{URL: t.url, Reason: raw.FailureReason}
+	}
+
+	resp := &AnnounceResponse{
+		Interval:    time.Duration(raw.Interval) * time.Second,
+		MinInterval: time.Duration(raw.MinInterval) * time.Second,
+		TrackerID:   raw.TrackerID,
+		Seeders:     raw.Complete,
+		Leechers:    raw.Incomplete,
+		Warning:     raw.WarningMessage,
+	}
+
+	var peers4, peers6 []peers.Peer
+	if raw.Peers != nil {
+		peers4, err = peers.Unmarshal(raw.Peers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if raw.Peers6 != nil {
+		peers6, err = peers.Unmarshal6(raw.Peers6)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp.Peers = peers.Merge(peers4, peers6)
+
+	return resp, nil
+}
+
+type httpScrapeResponse struct {
+	FailureReason string                    `bencode:"failure reason"`
+	Files         map[string]httpScrapeFile `bencode:"files"`
+}
+
+type httpScrapeFile struct {
+	Complete   int64 `bencode:"complete"`
+	Downloaded int64 `bencode:"downloaded"`
+	Incomplete int64 `bencode:"incomplete"`
+}
+
+// Scrape fetches the swarm counters of the given torrents. Torrents the
+// tracker does not know are missing from the result.
+func (t *HTTPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error) {
+	scrapeURL, err := ScrapeURL(t.url)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	for _, hash := range infoHashes {
+		params.Add("info_hash", string(hash[:]))
+	}
+
+	var raw httpScrapeResponse
+	err = t.get(ctx, scrapeURL, params, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.FailureReason != "" {
+		return nil, &FailureError{URL: scrapeURL, Reason: raw.FailureReason}
+	}
+
+	res := make(map[[20]byte]ScrapeResult, len(raw.Files))
+	for hash, file := range raw.Files {
+		if len(hash) != 20 {
+			continue
+		}
+		res[[20]byte([]byte(hash))] = ScrapeResult{
+			Seeders:   file.Complete,
+			Completed: file.Downloaded,
+			Leechers:  file.Incomplete,
+		}
+	}
+
+	return res, nil
+}
+
+// ScrapeURL derives the scrape URL of an HTTP tracker: the last path
+// component of the announce URL must start with "announce", which is
+// replaced by "scrape".
+func ScrapeURL(announceURL string) (string, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return "", err
+	}
+
+	slash := strings.LastIndex(u.Path, "/")
+	last := u.Path[slash+1:]
+	if !strings.HasPrefix(last, "announce") {
+		return "", ErrScrapeUnsupported
+	}
+
+	u.Path = u.Path[:slash+1] + "scrape" + strings.TrimPrefix(last, "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// get requests base with params appended to its query and decodes the
+// bencoded body into v.
+func (t *HTTPTracker) get(ctx context.Context, base string, params url.Values, v interface{}) error {
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+separator+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tracker %s returned %s", base, resp.Status)
+	}
+
+	decoder := bencode.NewDecoder(io.LimitReader(resp.Body, maxResponseSize))
+	err = decoder.Decode(v)
+	if err != nil {
+		return fmt.Errorf("error while decoding response from %s: %v", base, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"bitTorrentClient/bencode"
+	"bitTorrentClient/peers"
+)
+
+var httpEvents = map[string]AnnounceEvent{
+	"":          EventNone,
+	"completed": EventCompleted,
+	"started":   EventStarted,
+	"stopped":   EventStopped,
+}
+
+type httpAnnounceResponse struct {
+	Interval    int64       `bencode:"interval"`
+	MinInterval int64       `bencode:"min interval,omitempty"`
+	Complete    int64       `bencode:"complete"`
+	Incomplete  int64       `bencode:"incomplete"`
+	Peers       interface{} `bencode:"peers"`
+	Peers6      []byte      `bencode:"peers6,omitempty"`
+}
+
+type httpPeer struct {
+	PeerID []byte `bencode:"peer id,omitempty"`
+	IP     string `bencode:"ip"`
+	Port   int64  `bencode:"port"`
+}
+
+type httpScrapeResponse struct {
+	Files map[string]httpScrapeFile `bencode:"files"`
+}
+
+type httpScrapeFile struct {
+	Complete   int64 `bencode:"complete"`
+	Downloaded int64 `bencode:"downloaded"`
+	Incomplete int64 `bencode:"incomplete"`
+}
+
+type httpFailure struct {
+	FailureReason string `bencode:"failure reason"`
+}
+
+// Handler returns the HTTP handler serving /announce and /scrape.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/announce", s.serveAnnounce)
+	mux.HandleFunc("/scrape", s.serveScrape)
+	return mux
+}
+
+func (s *Server) serveAnnounce(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	a, err := parseAnnounce(r)
+	if err != nil {
+		writeBencode(w, httpFailure{FailureReason: err.Error()})
+		return
+	}
+
+	list, stats, err := s.Announce(a)
+	if err != nil {
+		writeBencode(w, httpFailure{FailureReason: err.Error()})
+		return
+	}
+
+	resp := httpAnnounceResponse{
+		Interval:    int64(s.Interval.Seconds()),
+		MinInterval: int64(s.MinInterval.Seconds()),
+		Complete:    stats.Seeders,
+		Incomplete:  stats.Leechers,
+	}
+
+	if query.Get("compact") == "1" {
+		plain := make([]peers.Peer, len(list))
+		for i, peer := range list {
+			plain[i] = peer.Peer
+		}
+		v4, v6 := peers.Compact(plain)
+		resp.Peers = v4
+		if v4 == nil {
+			resp.Peers = []byte{}
+		}
+		resp.Peers6 = v6
+	} else {
+		noPeerID := query.Get("no_peer_id") == "1"
+		dicts := make([]httpPeer, len(list))
+		for i, peer := range list {
+			dicts[i] = httpPeer{IP: peer.IP.String(), Port: int64(peer.Port)}
+			if !noPeerID {
+				dicts[i].PeerID = peer.ID[:]
+			}
+		}
+		resp.Peers = dicts
+	}
+
+	writeBencode(w, resp)
+}
+
+func (s *Server) serveScrape(w http.ResponseWriter, r *http.Request) {
+	var hashes [][20]byte
+	for _, value := range r.URL.Query()["info_hash"] {
+		if len(value) != 20 {
+			writeBencode(w, httpFailure{FailureReason: "invalid info_hash"})
+			return
+		}
+		hashes = append(hashes, [20]byte([]byte(value)))
+	}
+
+	resp := httpScrapeResponse{Files: make(map[string]httpScrapeFile)}
+	for hash, stats := range s.Scrape(hashes) {
+		resp.Files[string(hash[:])] = httpScrapeFile{
+			Complete:   stats.Seeders,
+			Downloaded: stats.Completed,
+			Incomplete: stats.Leechers,
+		}
+	}
+
+	writeBencode(w, resp)
+}
+
+func parseAnnounce(r *http.Request) (*Announce, error) {
+	query := r.URL.Query()
+
+	infoHash := query.Get("info_hash")
+	if len(infoHash) != 20 {
+		return nil, fmt.Errorf("invalid info_hash")
+	}
+	peerId := query.Get("peer_id")
+	if len(peerId) != 20 {
+		return nil, fmt.Errorf("invalid peer_id")
+	}
+
+	port, err := strconv.ParseUint(query.Get("port"), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port")
+	}
+	left, err := strconv.ParseInt(query.Get("left"), 10, 64)
+	if err != nil || left < 0 {
+		return nil, fmt.Errorf("invalid left")
+	}
+
+	event, ok := httpEvents[query.Get("event")]
+	if !ok {
+		return nil, fmt.Errorf("invalid event")
+	}
+
+	a := &Announce{
+		InfoHash: [20]byte([]byte(infoHash)),
+		PeerID:   [20]byte([]byte(peerId)),
+		Port:     uint16(port),
+		Left:     left,
+		Event:    event,
+		IPv4:     net.ParseIP(query.Get("ipv4")).To4(),
+	}
+
+	if ip6 := net.ParseIP(query.Get("ipv6")); ip6 != nil && ip6.To4() == nil {
+		a.IPv6 = ip6
+	}
+
+	if numWant := query.Get("numwant"); numWant != "" {
+		a.NumWant, err = strconv.Atoi(numWant)
+		if err != nil {
+			return nil, fmt.Errorf("invalid numwant")
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote address")
+	}
+	a.IP = net.ParseIP(host)
+
+	return a, nil
+}
+
+func writeBencode(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "text/plain")
+	err := bencode.NewEncoder(w).Encode(v)
+	if err != nil {
+		fmt.Printf("tracker: error while writing response: %v\n", err)
+	}
+}
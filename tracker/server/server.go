@@ -0,0 +1,276 @@
+// Package server implements a BitTorrent tracker that can be embedded in a
+// program or run with the tracker command. It answers HTTP announces and
+// scrapes and, optionally, the UDP tracker protocol (BEP 15).
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+const (
+	DefaultInterval    = 30 * time.Minute
+	DefaultMinInterval = 5 * time.Minute
+
+	defaultNumWant = 50
+	maxNumWant     = 200
+)
+
+// AnnounceEvent is the event of an announce, as sent by the client.
+type AnnounceEvent int
+
+const (
+	EventNone AnnounceEvent = iota
+	EventCompleted
+	EventStarted
+	EventStopped
+)
+
+// Announce is an announce request, independent of the protocol it came in.
+type Announce struct {
+	InfoHash [20]byte
+	PeerID   [20]byte
+	// IP is the address the request came from; IPv4 and IPv6 are the
+	// additional addresses the client reported (BEP 7), used only for
+	// requests from a trusted network.
+	IP      net.IP
+	IPv4    net.IP
+	IPv6    net.IP
+	Port    uint16
+	Left    int64
+	Event   AnnounceEvent
+	NumWant int
+}
+
+// Peer is a peer handed out in an announce response.
+type Peer struct {
+	ID [20]byte
+	peers.Peer
+}
+
+// SwarmStats are the counters of one torrent, as reported by scrape.
+type SwarmStats struct {
+	Seeders   int64
+	Completed int64
+	Leechers  int64
+}
+
+type peerEntry struct {
+	id       [20]byte
+	ip4      net.IP
+	ip6      net.IP
+	port     uint16
+	left     int64
+	lastSeen time.Time
+	// completed is set once the peer's completed event was counted
+	completed bool
+}
+
+type swarm struct {
+	peers     map[[20]byte]*peerEntry
+	completed int64
+}
+
+// Server keeps the swarms of the torrents it tracks. The exported fields must
+// be set before the server starts serving.
+type Server struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	// PeerTTL is how long a peer stays in its swarm without announcing.
+	PeerTTL time.Duration
+	// TrustedNetworks may announce addresses other than the one a request
+	// comes from, such as clients behind the same NAT as the tracker giving
+	// their public address. Requests from anywhere else, the local network
+	// included, are recorded under their source address, as they could
+	// otherwise make the tracker hand out a victim's address.
+	TrustedNetworks []*net.IPNet
+
+	mu        sync.Mutex
+	swarms    map[[20]byte]*swarm
+	allowlist map[[20]byte]bool
+}
+
+func New() *Server {
+	return &Server{
+		Interval:    DefaultInterval,
+		MinInterval: DefaultMinInterval,
+		PeerTTL:     2 * DefaultInterval,
+		swarms:      make(map[[20]byte]*swarm),
+	}
+}
+
+// Allow adds infoHash to the allowlist. Once anything is allowed, announces
+// for torrents not on the list are refused.
+func (s *Server) Allow(infoHash [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.allowlist == nil {
+		s.allowlist = make(map[[20]byte]bool)
+	}
+	s.allowlist[infoHash] = true
+}
+
+// Announce records the peer in its swarm and returns the other peers of the
+// swarm along with the swarm's counters.
+func (s *Server) Announce(a *Announce) ([]Peer, SwarmStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.allowlist != nil && !s.allowlist[a.InfoHash] {
+		return nil, SwarmStats{}, fmt.Errorf("torrent is not tracked here")
+	}
+	if a.Port == 0 {
+		return nil, SwarmStats{}, fmt.Errorf("invalid port")
+	}
+
+	sw := s.swarms[a.InfoHash]
+	if sw == nil {
+		sw = &swarm{peers: make(map[[20]byte]*peerEntry)}
+		s.swarms[a.InfoHash] = sw
+	}
+
+	now := time.Now()
+	s.expire(sw, now)
+
+	entry := sw.peers[a.PeerID]
+	if a.Event == EventStopped {
+		delete(sw.peers, a.PeerID)
+		return nil, sw.stats(), nil
+	}
+
+	if entry == nil {
+		entry = &peerEntry{id: a.PeerID}
+		sw.peers[a.PeerID] = entry
+	}
+	if a.Event == EventCompleted && !entry.completed {
+		entry.completed = true
+		sw.completed++
+	}
+
+	entry.port = a.Port
+	entry.left = a.Left
+	entry.lastSeen = now
+	entry.ip4, entry.ip6 = nil, nil
+	addrs := []net.IP{a.IP}
+	if s.trusted(a.IP) {
+		addrs = append(addrs, a.IPv4, a.IPv6)
+	}
+	for _, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			entry.ip4 = ip4
+		} else if ip != nil {
+			entry.ip6 = ip
+		}
+	}
+
+	numWant := a.NumWant
+	if numWant <= 0 {
+		numWant = defaultNumWant
+	}
+	numWant = min(numWant, maxNumWant)
+
+	// map iteration order is random, which spreads the load across peers;
+	// numwant counts peers, of which a dual-stack one has two entries
+	var res []Peer
+	count := 0
+	for id, other := range sw.peers {
+		if count >= numWant {
+			break
+		}
+		// seeders have no use for other seeders
+		if id == a.PeerID || (a.Left == 0 && other.left == 0) {
+			continue
+		}
+		if other.ip4 != nil {
+			res = append(res, Peer{ID: id, Peer: peers.Peer{IP: other.ip4, Port: other.port}})
+		}
+		if other.ip6 != nil {
+			res = append(res, Peer{ID: id, Peer: peers.Peer{IP: other.ip6, Port: other.port}})
+		}
+		count++
+	}
+
+	return res, sw.stats(), nil
+}
+
+// trusted reports whether a request from ip may announce other addresses.
+func (s *Server) trusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.TrustedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scrape returns the counters of the given torrents, or of every torrent
+// when infoHashes is empty. Unknown torrents are left out.
+func (s *Server) Scrape(infoHashes [][20]byte) map[[20]byte]SwarmStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	res := make(map[[20]byte]SwarmStats)
+
+	if len(infoHashes) == 0 {
+		for hash, sw := range s.swarms {
+			s.expire(sw, now)
+			res[hash] = sw.stats()
+		}
+		return res
+	}
+
+	for _, hash := range infoHashes {
+		sw := s.swarms[hash]
+		if sw == nil {
+			continue
+		}
+		s.expire(sw, now)
+		res[hash] = sw.stats()
+	}
+	return res
+}
+
+// Sweep drops expired peers from every swarm and forgets swarms that are
+// left empty.
+func (s *Server) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, sw := range s.swarms {
+		s.expire(sw, now)
+		if len(sw.peers) == 0 && sw.completed == 0 {
+			delete(s.swarms, hash)
+		}
+	}
+}
+
+// expire removes the peers of sw that stopped announcing. s.mu must be held.
+func (s *Server) expire(sw *swarm, now time.Time) {
+	for id, entry := range sw.peers {
+		if now.Sub(entry.lastSeen) > s.PeerTTL {
+			delete(sw.peers, id)
+		}
+	}
+}
+
+func (sw *swarm) stats() SwarmStats {
+	stats := SwarmStats{Completed: sw.completed}
+	for _, entry := range sw.peers {
+		if entry.left == 0 {
+			stats.Seeders++
+		} else {
+			stats.Leechers++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"bitTorrentClient/bencode"
+	"bitTorrentClient/tracker"
+)
+
+var testHash = [20]byte{0xaa, 0xbb}
+
+func startHTTP(t *testing.T, s *Server) string {
+	t.Helper()
+
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce"
+}
+
+func startUDP(t *testing.T, s *Server) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeUDP(conn)
+	t.Cleanup(func() { conn.Close() })
+	return "udp://" + conn.LocalAddr().String()
+}
+
+func newUDPTracker(t *testing.T, announceURL string) *tracker.UDPTracker {
+	t.Helper()
+
+	tr, err := tracker.NewUDPTracker(announceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.RetransmitBase = 200 * time.Millisecond
+	tr.MaxRetries = 2
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func announceRequest(id byte, port uint16, left int64, event tracker.Event) *tracker.AnnounceRequest {
+	return &tracker.AnnounceRequest{
+		InfoHash: testHash,
+		PeerID:   [20]byte{id},
+		Port:     port,
+		Left:     left,
+		Event:    event,
+	}
+}
+
+// testSwarm announces a leecher and a seeder through tr and checks what a
+// second leecher is told.
+func testSwarm(t *testing.T, tr tracker.Tracker) {
+	ctx := context.Background()
+
+	_, err := tr.Announce(ctx, announceRequest(1, 7001, 100, tracker.EventStarted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tr.Announce(ctx, announceRequest(2, 7002, 0, tracker.EventStarted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := tr.Announce(ctx, announceRequest(3, 7003, 50, tracker.EventStarted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Seeders != 1 || resp.Leechers != 2 {
+		t.Errorf("got seeders=%d leechers=%d, want 1 and 2", resp.Seeders, resp.Leechers)
+	}
+	if resp.Interval != DefaultInterval {
+		t.Errorf("got interval %s, want %s", resp.Interval, DefaultInterval)
+	}
+
+	var got []string
+	for _, peer := range resp.Peers {
+		got = append(got, peer.String())
+	}
+	sort.Strings(got)
+	want := []string{"127.0.0.1:7001", "127.0.0.1:7002"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got peers %v, want %v", got, want)
+	}
+
+	// a seeder is not sent the other seeders
+	resp, err = tr.Announce(ctx, announceRequest(2, 7002, 0, tracker.EventNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Peers) != 2 {
+		t.Errorf("seeder got peers %v, want the 2 leechers", resp.Peers)
+	}
+
+	// completed is counted once, stopped leaves the swarm
+	for i := 0; i < 2; i++ {
+		_, err = tr.Announce(ctx, announceRequest(1, 7001, 0, tracker.EventCompleted))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = tr.Announce(ctx, announceRequest(3, 7003, 50, tracker.EventStopped))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scraper, ok := tr.(tracker.Scraper)
+	if !ok {
+		t.Fatalf("%T cannot scrape", tr)
+	}
+	other := [20]byte{0xcc}
+	res, err := scraper.Scrape(ctx, [][20]byte{testHash, other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want2 := tracker.ScrapeResult{Seeders: 2, Completed: 1, Leechers: 0}
+	if res[testHash] != want2 {
+		t.Errorf("got scrape %+v, want %+v", res[testHash], want2)
+	}
+	if res[other] != (tracker.ScrapeResult{}) {
+		t.Errorf("got scrape %+v for an unknown torrent", res[other])
+	}
+}
+
+func TestHTTPSwarm(t *testing.T) {
+	testSwarm(t, tracker.NewHTTPTracker(startHTTP(t, New())))
+}
+
+func TestUDPSwarm(t *testing.T) {
+	testSwarm(t, newUDPTracker(t, startUDP(t, New())))
+}
+
+func TestAllowlist(t *testing.T) {
+	s := New()
+	s.Allow([20]byte{0x01})
+
+	for _, tr := range []tracker.Tracker{
+		tracker.NewHTTPTracker(startHTTP(t, s)),
+		newUDPTracker(t, startUDP(t, s)),
+	} {
+		_, err := tr.Announce(context.Background(), announceRequest(1, 7001, 100, tracker.EventStarted))
+		var failure *tracker.FailureError
+		if !errors.As(err, &failure) {
+			t.Errorf("%s: got error %v for a torrent not allowed, want a failure", tr.URL(), err)
+		}
+	}
+}
+
+func TestReportedAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		want    string
+	}{
+		// loopback is as untrusted as any other network unless configured
+		{"untrusted", nil, "127.0.0.1:7001"},
+		{"other network trusted", []string{"10.0.0.0/8"}, "127.0.0.1:7001"},
+		{"trusted", []string{"127.0.0.0/8"}, "192.0.2.7:7001"},
+	}
+
+	for _, test := range tests {
+		s := New()
+		for _, cidr := range test.trusted {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.TrustedNetworks = append(s.TrustedNetworks, network)
+		}
+
+		for _, tr := range []tracker.Tracker{
+			tracker.NewHTTPTracker(startHTTP(t, s)),
+			newUDPTracker(t, startUDP(t, s)),
+		} {
+			req := announceRequest(1, 7001, 100, tracker.EventStarted)
+			req.IPv4 = net.IPv4(192, 0, 2, 7)
+			_, err := tr.Announce(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := tr.Announce(context.Background(), announceRequest(2, 7002, 100, tracker.EventStarted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Peers) != 1 || resp.Peers[0].String() != test.want {
+				t.Errorf("%s over %s: got peers %v, want [%s]", test.name, tr.URL(), resp.Peers, test.want)
+			}
+		}
+	}
+}
+
+func TestNumWantCountsPeers(t *testing.T) {
+	s := New()
+	for i := byte(1); i <= 5; i++ {
+		_, _, err := s.Announce(&Announce{
+			InfoHash: testHash,
+			PeerID:   [20]byte{i},
+			IP:       net.IPv4(192, 0, 2, i),
+			Port:     7000,
+			Left:     100,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the dual-stack peers have two entries each, which count as one peer
+	_, network, _ := net.ParseCIDR("192.0.2.0/24")
+	s.TrustedNetworks = []*net.IPNet{network}
+	for i := byte(1); i <= 5; i++ {
+		s.Announce(&Announce{
+			InfoHash: testHash,
+			PeerID:   [20]byte{i},
+			IP:       net.IPv4(192, 0, 2, i),
+			IPv6:     net.ParseIP("2001:db8::" + string('0'+i)),
+			Port:     7000,
+			Left:     100,
+		})
+	}
+
+	list, _, err := s.Announce(&Announce{InfoHash: testHash, PeerID: [20]byte{9}, IP: net.IPv4(198, 51, 100, 1), Port: 7000, Left: 100, NumWant: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[[20]byte]int)
+	for _, peer := range list {
+		ids[peer.ID]++
+	}
+	if len(ids) != 2 || len(list) != 4 {
+		t.Errorf("got %d entries of %d peers, want 4 entries of 2 peers", len(list), len(ids))
+	}
+}
+
+func TestHTTPNonCompact(t *testing.T) {
+	s := New()
+	announceURL := startHTTP(t, s)
+
+	_, err := tracker.NewHTTPTracker(announceURL).Announce(context.Background(), announceRequest(1, 7001, 100, tracker.EventStarted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type dictPeer struct {
+		PeerID []byte `bencode:"peer id,omitempty"`
+		IP     string `bencode:"ip"`
+		Port   int64  `bencode:"port"`
+	}
+	for _, noPeerID := range []bool{false, true} {
+		var resp struct {
+			Peers []dictPeer `bencode:"peers"`
+		}
+		params := url.Values{}
+		params.Set("info_hash", string(testHash[:]))
+		params.Set("peer_id", string([]byte{2, 19: 0}))
+		params.Set("port", "7002")
+		params.Set("left", "100")
+		if noPeerID {
+			params.Set("no_peer_id", "1")
+		}
+
+		httpResp, err := http.Get(announceURL + "?" + params.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = bencode.NewDecoder(httpResp.Body).Decode(&resp)
+		httpResp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Peers) != 1 || resp.Peers[0].IP != "127.0.0.1" || resp.Peers[0].Port != 7001 {
+			t.Fatalf("got peers %+v, want 127.0.0.1:7001", resp.Peers)
+		}
+		hasID := len(resp.Peers[0].PeerID) == 20 && resp.Peers[0].PeerID[0] == 1
+		if hasID == noPeerID {
+			t.Errorf("no_peer_id=%v: got peer id %x", noPeerID, resp.Peers[0].PeerID)
+		}
+	}
+}
+
+func TestUDPConnectionID(t *testing.T) {
+	s := New()
+	secret := []byte("secret")
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 6881}
+	other := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 6882}
+	now := time.Now()
+
+	scrape := func(connectionID uint64, from *net.UDPAddr) uint32 {
+		packet := binary.BigEndian.AppendUint64(nil, connectionID)
+		packet = binary.BigEndian.AppendUint32(packet, actionScrape)
+		packet = append(packet, 1, 2, 3, 4)
+		packet = append(packet, testHash[:]...)
+		resp := s.handleUDP(packet, from, secret)
+		return binary.BigEndian.Uint32(resp[0:4])
+	}
+
+	tests := []struct {
+		name         string
+		connectionID uint64
+		from         *net.UDPAddr
+		want         uint32
+	}{
+		{"current", makeConnectionID(secret, addr, now), addr, actionScrape},
+		{"previous window", makeConnectionID(secret, addr, now.Add(-connectionIDWindow)), addr, actionScrape},
+		{"expired", makeConnectionID(secret, addr, now.Add(-3*connectionIDWindow)), addr, actionError},
+		{"other address", makeConnectionID(secret, addr, now), other, actionError},
+		{"other secret", makeConnectionID([]byte("other"), addr, now), addr, actionError},
+		{"protocol id", udpProtocolID, addr, actionError},
+	}
+	for _, test := range tests {
+		if got := scrape(test.connectionID, test.from); got != test.want {
+			t.Errorf("%s: got action %d, want %d", test.name, got, test.want)
+		}
+	}
+
+	// a connect must carry the protocol id
+	packet := binary.BigEndian.AppendUint64(nil, 12345)
+	packet = binary.BigEndian.AppendUint32(packet, actionConnect)
+	packet = append(packet, 1, 2, 3, 4)
+	if resp := s.handleUDP(packet, addr, secret); resp != nil {
+		t.Errorf("connect without the protocol id was answered: %x", resp)
+	}
+}
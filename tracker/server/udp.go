@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+// UDP tracker protocol constants (BEP 15)
+const (
+	udpProtocolID = 0x41727101980
+
+	actionConnect  = 0
+	actionAnnounce = 1
+	actionScrape   = 2
+	actionError    = 3
+
+	// connection ids are valid for the minute they were issued in and the
+	// next one
+	connectionIDWindow = time.Minute
+
+	maxScrapeHashes = 74
+)
+
+// ServeUDP answers UDP tracker requests on conn until it is closed.
+// Connection ids are derived from the client address and a secret, so no
+// state is kept between the connect and the requests that follow.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	var secret [32]byte
+	_, err := rand.Read(secret[:])
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || n < 16 {
+			continue
+		}
+
+		resp := s.handleUDP(buf[:n], udpAddr, secret[:])
+		if resp != nil {
+			conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *Server) handleUDP(packet []byte, addr *net.UDPAddr, secret []byte) []byte {
+	connectionID := binary.BigEndian.Uint64(packet[0:8])
+	action := binary.BigEndian.Uint32(packet[8:12])
+	tid := packet[12:16]
+	body := packet[16:]
+
+	if action == actionConnect {
+		if connectionID != udpProtocolID {
+			return nil
+		}
+		resp := udpHeader(actionConnect, tid)
+		return binary.BigEndian.AppendUint64(resp, makeConnectionID(secret, addr, time.Now()))
+	}
+
+	if !validConnectionID(secret, addr, connectionID) {
+		return udpError(tid, "invalid connection id")
+	}
+
+	switch action {
+	case actionAnnounce:
+		return s.handleUDPAnnounce(body, addr, tid)
+	case actionScrape:
+		return s.handleUDPScrape(body, tid)
+	}
+	return udpError(tid, "unknown action")
+}
+
+func (s *Server) handleUDPAnnounce(body []byte, addr *net.UDPAddr, tid []byte) []byte {
+	if len(body) < 82 {
+		return udpError(tid, "short announce request")
+	}
+
+	event := AnnounceEvent(binary.BigEndian.Uint32(body[64:68]))
+	if event > EventStopped {
+		return udpError(tid, "invalid event")
+	}
+
+	a := &Announce{
+		InfoHash: [20]byte(body[0:20]),
+		PeerID:   [20]byte(body[20:40]),
+		IP:       addr.IP,
+		Left:     int64(binary.BigEndian.Uint64(body[48:56])),
+		Event:    event,
+		NumWant:  int(int32(binary.BigEndian.Uint32(body[76:80]))),
+		Port:     binary.BigEndian.Uint16(body[80:82]),
+	}
+	// an IP of 0 means the address the request came from
+	if ip := net.IP(body[68:72]); !ip.IsUnspecified() {
+		a.IPv4 = append(net.IP(nil), ip...)
+	}
+
+	list, stats, err := s.Announce(a)
+	if err != nil {
+		return udpError(tid, err.Error())
+	}
+
+	resp := udpHeader(actionAnnounce, tid)
+	resp = binary.BigEndian.AppendUint32(resp, uint32(s.Interval.Seconds()))
+	resp = binary.BigEndian.AppendUint32(resp, uint32(stats.Leechers))
+	resp = binary.BigEndian.AppendUint32(resp, uint32(stats.Seeders))
+
+	// the peer list has the address family of the request
+	plain := make([]peers.Peer, len(list))
+	for i, peer := range list {
+		plain[i] = peer.Peer
+	}
+	v4, v6 := peers.Compact(plain)
+	if addr.IP.To4() != nil {
+		resp = append(resp, v4...)
+	} else {
+		resp = append(resp, v6...)
+	}
+
+	return resp
+}
+
+func (s *Server) handleUDPScrape(body []byte, tid []byte) []byte {
+	if len(body) == 0 || len(body)%20 != 0 || len(body)/20 > maxScrapeHashes {
+		return udpError(tid, "invalid scrape request")
+	}
+
+	hashes := make([][20]byte, len(body)/20)
+	for i := range hashes {
+		hashes[i] = [20]byte(body[i*20 : i*20+20])
+	}
+
+	results := s.Scrape(hashes)
+
+	resp := udpHeader(actionScrape, tid)
+	for _, hash := range hashes {
+		stats := results[hash]
+		resp = binary.BigEndian.AppendUint32(resp, uint32(stats.Seeders))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(stats.Completed))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(stats.Leechers))
+	}
+	return resp
+}
+
+func udpHeader(action uint32, tid []byte) []byte {
+	resp := binary.BigEndian.AppendUint32(nil, action)
+	return append(resp, tid...)
+}
+
+func udpError(tid []byte, message string) []byte {
+	return append(udpHeader(actionError, tid), message...)
+}
+
+func makeConnectionID(secret []byte, addr *net.UDPAddr, now time.Time) uint64 {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(addr.IP.To16())
+	mac.Write(binary.BigEndian.AppendUint16(nil, uint16(addr.Port)))
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(now.Unix()/int64(connectionIDWindow.Seconds()))))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+func validConnectionID(secret []byte, addr *net.UDPAddr, connectionID uint64) bool {
+	now := time.Now()
+	return connectionID == makeConnectionID(secret, addr, now) ||
+		connectionID == makeConnectionID(secret, addr, now.Add(-connectionIDWindow))
+}
@@ -0,0 +1,253 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+// defaultTrackerTimeout bounds a single tracker's announce inside a tier, so
+// that an unresponsive tracker does not hold up the ones after it.
+const defaultTrackerTimeout = 45 * time.Second
+
+// TrackerStatus is what is known about one tracker of a torrent.
+type TrackerStatus struct {
+	URL          string
+	Tier         int
+	LastAnnounce time.Time
+	Peers        int
+	LastError    error
+}
+
+type tierEntry struct {
+	tracker Tracker
+	status  TrackerStatus
+	// trackerID is the id this tracker asked us to send back; it replaces
+	// the one in the request, which may come from another tracker.
+	trackerID string
+}
+
+// Tiers announces to the trackers of a torrent following the multitracker
+// rules of BEP 12: trackers are shuffled within each tier, tried in order,
+// a tracker that answers moves to the front of its tier, and the next tier is
+// only tried once every tracker of the previous one failed. Tiers is itself a
+// Tracker, so an Announcer can drive it.
+type Tiers struct {
+	// AllTiers announces to every tier in parallel and merges the peers,
+	// instead of stopping at the first tier that answers.
+	AllTiers bool
+	// TrackerTimeout bounds each tracker's announce.
+	TrackerTimeout time.Duration
+
+	mu    sync.Mutex
+	tiers [][]*tierEntry
+}
+
+// NewTiers builds the tiers from an announce-list. URLs with an unsupported
+// protocol are left out.
+func NewTiers(announceList [][]string) (*Tiers, error) {
+	t := &Tiers{TrackerTimeout: defaultTrackerTimeout}
+
+	for _, urls := range announceList {
+		var tier []*tierEntry
+		for _, announceURL := range urls {
+			trk, err := New(announceURL)
+			if err != nil {
+				continue
+			}
+			tier = append(tier, &tierEntry{
+				tracker: trk,
+				status:  TrackerStatus{URL: announceURL, Tier: len(t.tiers)},
+			})
+		}
+
+		if len(tier) == 0 {
+			continue
+		}
+
+		rand.Shuffle(len(tier), func(i, j int) {
+			tier[i], tier[j] = tier[j], tier[i]
+		})
+		t.tiers = append(t.tiers, tier)
+	}
+
+	if len(t.tiers) == 0 {
+		return nil, fmt.Errorf("no supported trackers in the announce list")
+	}
+	return t, nil
+}
+
+// URL returns the tracker that would be tried first.
+func (t *Tiers) URL() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tiers[0][0].status.URL
+}
+
+// Status returns the state of every tracker in tier order.
+func (t *Tiers) Status() []TrackerStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var res []TrackerStatus
+	for _, tier := range t.tiers {
+		for _, entry := range tier {
+			res = append(res, entry.status)
+		}
+	}
+	return res
+}
+
+// Close releases the trackers that hold a connection, such as the sockets of
+// UDP trackers. Tiers must not be used afterwards.
+func (t *Tiers) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var firstErr error
+	for _, tier := range t.tiers {
+		for _, entry := range tier {
+			closer, ok := entry.tracker.(io.Closer)
+			if !ok {
+				continue
+			}
+			err := closer.Close()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (t *Tiers) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	if !t.AllTiers {
+		var lastErr error
+		for i := range t.tiers {
+			resp, err := t.announceTier(ctx, i, req)
+			if err == nil {
+				return resp, nil
+			}
+			lastErr = err
+		}
+		return nil, fmt.Errorf("all trackers failed, last error: %w", lastErr)
+	}
+
+	responses := make([]*AnnounceResponse, len(t.tiers))
+	errs := make([]error, len(t.tiers))
+
+	var wg sync.WaitGroup
+	for i := range t.tiers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], errs[i] = t.announceTier(ctx, i, req)
+		}(i)
+	}
+	wg.Wait()
+
+	return mergeResponses(responses, errs)
+}
+
+// announceTier tries the trackers of one tier in order and promotes the
+// first that answers to the front of the tier.
+func (t *Tiers) announceTier(ctx context.Context, index int, req *AnnounceRequest) (*AnnounceResponse, error) {
+	t.mu.Lock()
+	tier := append([]*tierEntry(nil), t.tiers[index]...)
+	t.mu.Unlock()
+
+	var lastErr error
+	for _, entry := range tier {
+		t.mu.Lock()
+		entryReq := *req
+		entryReq.TrackerID = entry.trackerID
+		t.mu.Unlock()
+
+		trackerCtx, cancel := context.WithTimeout(ctx, t.TrackerTimeout)
+		resp, err := entry.tracker.Announce(trackerCtx, &entryReq)
+		cancel()
+
+		t.mu.Lock()
+		entry.status.LastAnnounce = time.Now()
+		entry.status.LastError = err
+		if err == nil {
+			entry.status.Peers = len(resp.Peers)
+			if resp.TrackerID != "" {
+				entry.trackerID = resp.TrackerID
+			}
+			t.promote(index, entry)
+		}
+		t.mu.Unlock()
+
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+// promote moves entry to the front of its tier. t.mu must be held.
+func (t *Tiers) promote(index int, entry *tierEntry) {
+	tier := t.tiers[index]
+	for i, item := range tier {
+		if item == entry {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = entry
+			return
+		}
+	}
+}
+
+// mergeResponses combines the answers of all tiers: peers are merged without
+// duplicates, the shortest interval wins, and the swarm counters come from
+// the first tier that answered.
+func mergeResponses(responses []*AnnounceResponse, errs []error) (*AnnounceResponse, error) {
+	var merged *AnnounceResponse
+
+	for _, resp := range responses {
+		if resp == nil {
+			continue
+		}
+
+		if merged == nil {
+			merged = &AnnounceResponse{
+				Interval:    resp.Interval,
+				MinInterval: resp.MinInterval,
+				TrackerID:   resp.TrackerID,
+				Seeders:     resp.Seeders,
+				Leechers:    resp.Leechers,
+				Warning:     resp.Warning,
+			}
+		} else if resp.Interval > 0 && (merged.Interval == 0 || resp.Interval < merged.Interval) {
+			merged.Interval = resp.Interval
+		}
+
+		merged.Peers = peers.Merge(merged.Peers, resp.Peers)
+	}
+
+	if merged == nil {
+		var lastErr error
+		for _, err := range errs {
+			if err != nil {
+				lastErr = err
+			}
+		}
+		return nil, fmt.Errorf("all trackers failed, last error: %w", lastErr)
+	}
+
+	if merged.Peers == nil {
+		merged.Peers = []peers.Peer{}
+	}
+	return merged, nil
+}
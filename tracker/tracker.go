@@ -0,0 +1,125 @@
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+type Event string
+
+const (
+	EventNone      Event = ""
+	EventStarted   Event = "started"
+	EventCompleted Event = "completed"
+	EventStopped   Event = "stopped"
+)
+
+// peerIDPrefix is the Azureus-style client tag at the start of our peer ids.
+const peerIDPrefix = "-BC0001-"
+
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	NumWant    int
+	Key        uint32
+	TrackerID  string
+	// IPv4 and IPv6, if set, tell the tracker our addresses of the other
+	// family than the one the announce is sent over (BEP 7).
+	IPv4 net.IP
+	IPv6 net.IP
+}
+
+type AnnounceResponse struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	TrackerID   string
+	Seeders     int64
+	Leechers    int64
+	Peers       []peers.Peer
+	// Warning is the tracker's warning message; the response is still valid.
+	Warning string
+}
+
+// ScrapeResult holds the swarm counters a tracker reports for one torrent.
+type ScrapeResult struct {
+	Seeders   int64
+	Completed int64
+	Leechers  int64
+}
+
+// Tracker is a single tracker URL speaking one of the tracker protocols.
+type Tracker interface {
+	Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error)
+	URL() string
+}
+
+// Scraper is implemented by trackers that can report swarm counters for
+// several torrents in one request.
+type Scraper interface {
+	Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error)
+}
+
+// ErrScrapeUnsupported is returned by HTTP trackers whose announce URL does not
+// follow the convention from which the scrape URL is derived.
+var ErrScrapeUnsupported = errors.New("tracker does not support scrape")
+
+// New returns the Tracker for an http(s):// or udp:// announce URL.
+func New(announceURL string) (Tracker, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTPTracker(announceURL), nil
+	case "udp":
+		return NewUDPTracker(announceURL)
+	}
+	return nil, fmt.Errorf("unsupported tracker protocol %q", u.Scheme)
+}
+
+// FailureError is returned when the tracker answers with a failure reason.
+type FailureError struct {
+	URL    string
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return fmt.Sprintf("tracker %s failed: %s", e.URL, e.Reason)
+}
+
+// WarningError carries a tracker's warning message. It is reported alongside
+// a successful response rather than instead of one.
+type WarningError struct {
+	URL     string
+	Message string
+}
+
+func (e *WarningError) Error() string {
+	return fmt.Sprintf("tracker %s warning: %s", e.URL, e.Message)
+}
+
+// GeneratePeerID returns a random peer id carrying our client prefix.
+func GeneratePeerID() ([20]byte, error) {
+	var peerId [20]byte
+	copy(peerId[:], peerIDPrefix)
+
+	_, err := rand.Read(peerId[len(peerIDPrefix):])
+	if err != nil {
+		return peerId, err
+	}
+	return peerId, nil
+}
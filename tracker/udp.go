@@ -0,0 +1,312 @@
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"bitTorrentClient/peers"
+)
+
+// UDP tracker protocol constants (BEP 15)
+const (
+	udpProtocolID = 0x41727101980
+
+	actionConnect  = 0
+	actionAnnounce = 1
+	actionScrape   = 2
+	actionError    = 3
+
+	// a connection id may be used for one minute after it was received
+	connectionIDLifetime = time.Minute
+
+	// maxScrapeHashes is how many info-hashes fit in one scrape request
+	maxScrapeHashes = 74
+)
+
+var udpEvents = map[Event]uint32{
+	EventNone:      0,
+	EventCompleted: 1,
+	EventStarted:   2,
+	EventStopped:   3,
+}
+
+type UDPTracker struct {
+	url  string
+	host string
+
+	// RetransmitBase is the response timeout of the first attempt, doubled on
+	// each retransmission (15 * 2^n seconds in BEP 15).
+	RetransmitBase time.Duration
+	// MaxRetries is the number of retransmissions before giving up.
+	MaxRetries int
+
+	mu           sync.Mutex
+	conn         net.Conn
+	connectionID uint64
+	connectedAt  time.Time
+}
+
+func NewUDPTracker(announceURL string) (*UDPTracker, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid udp tracker url %q", announceURL)
+	}
+
+	return &UDPTracker{
+		url:            announceURL,
+		host:           u.Host,
+		RetransmitBase: 15 * time.Second,
+		MaxRetries:     8,
+	}, nil
+}
+
+func (t *UDPTracker) URL() string {
+	return t.url
+}
+
+func (t *UDPTracker) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error) {
+	t.mu.Lock()
+	err := t.dial(ctx)
+	t.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, 82)
+	copy(body[0:20], req.InfoHash[:])
+	copy(body[20:40], req.PeerID[:])
+	binary.BigEndian.PutUint64(body[40:48], uint64(req.Downloaded))
+	binary.BigEndian.PutUint64(body[48:56], uint64(req.Left))
+	binary.BigEndian.PutUint64(body[56:64], uint64(req.Uploaded))
+	binary.BigEndian.PutUint32(body[64:68], udpEvents[req.Event])
+	// the IP field is 32 bits wide, so it only carries an IPv4 address and
+	// stays 0, the sender's address, for trackers reached over IPv6 (BEP 15)
+	if ip4 := req.IPv4.To4(); ip4 != nil && !t.isIPv6() {
+		copy(body[68:72], ip4)
+	}
+	binary.BigEndian.PutUint32(body[72:76], req.Key)
+
+	numWant := int32(-1)
+	if req.NumWant > 0 {
+		numWant = int32(req.NumWant)
+	}
+	binary.BigEndian.PutUint32(body[76:80], uint32(numWant))
+	binary.BigEndian.PutUint16(body[80:82], req.Port)
+
+	resp, err := t.request(ctx, actionAnnounce, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp) < 12 {
+		return nil, fmt.Errorf("tracker %s sent a short announce response", t.url)
+	}
+
+	res := &AnnounceResponse{
+		Interval: time.Duration(binary.BigEndian.Uint32(resp[0:4])) * time.Second,
+		Leechers: int64(binary.BigEndian.Uint32(resp[4:8])),
+		Seeders:  int64(binary.BigEndian.Uint32(resp[8:12])),
+	}
+
+	// trackers reached over IPv6 answer with 18-byte IPv6 entries
+	if t.isIPv6() {
+		res.Peers, err = peers.Unmarshal6(resp[12:])
+	} else {
+		res.Peers, err = peers.Unmarshal(resp[12:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Scrape fetches the swarm counters of up to 74 torrents in one request.
+func (t *UDPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error) {
+	if len(infoHashes) == 0 || len(infoHashes) > maxScrapeHashes {
+		return nil, fmt.Errorf("udp scrape needs 1 to %d info-hashes, got %d", maxScrapeHashes, len(infoHashes))
+	}
+
+	body := make([]byte, 0, 20*len(infoHashes))
+	for _, hash := range infoHashes {
+		body = append(body, hash[:]...)
+	}
+
+	resp, err := t.request(ctx, actionScrape, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp) < 12*len(infoHashes) {
+		return nil, fmt.Errorf("tracker %s sent a short scrape response", t.url)
+	}
+
+	res := make(map[[20]byte]ScrapeResult, len(infoHashes))
+	for i, hash := range infoHashes {
+		entry := resp[i*12:]
+		res[hash] = ScrapeResult{
+			Seeders:   int64(binary.BigEndian.Uint32(entry[0:4])),
+			Completed: int64(binary.BigEndian.Uint32(entry[4:8])),
+			Leechers:  int64(binary.BigEndian.Uint32(entry[8:12])),
+		}
+	}
+
+	return res, nil
+}
+
+// request sends action with body, connecting first when there is no valid
+// connection id, and retransmits with exponential backoff until the tracker
+// answers. It returns the response after its action and transaction id.
+func (t *UDPTracker) request(ctx context.Context, action uint32, body []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for n := 0; n <= t.MaxRetries; n++ {
+		timeout := t.RetransmitBase << n
+
+		// the connection id can expire while we are still retrying
+		if t.connectionID == 0 || time.Since(t.connectedAt) > connectionIDLifetime {
+			resp, err := t.exchange(ctx, udpProtocolID, actionConnect, nil, timeout)
+			if isTimeout(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(resp) < 8 {
+				return nil, fmt.Errorf("tracker %s sent a short connect response", t.url)
+			}
+
+			t.connectionID = binary.BigEndian.Uint64(resp[0:8])
+			t.connectedAt = time.Now()
+		}
+
+		resp, err := t.exchange(ctx, t.connectionID, action, body, timeout)
+		if isTimeout(err) {
+			continue
+		}
+		return resp, err
+	}
+
+	return nil, fmt.Errorf("tracker %s did not respond after %d attempts", t.url, t.MaxRetries+1)
+}
+
+// dial opens the socket to the tracker if it is not open. t.mu must be held.
+func (t *UDPTracker) dial(ctx context.Context) error {
+	if t.conn != nil {
+		return nil
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", t.host)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+// exchange sends one packet and waits up to timeout for the response with the
+// same transaction id.
+func (t *UDPTracker) exchange(ctx context.Context, connectionID uint64, action uint32, body []byte, timeout time.Duration) ([]byte, error) {
+	var tidBuf [4]byte
+	rand.Read(tidBuf[:])
+	tid := binary.BigEndian.Uint32(tidBuf[:])
+
+	packet := make([]byte, 16, 16+len(body))
+	binary.BigEndian.PutUint64(packet[0:8], connectionID)
+	binary.BigEndian.PutUint32(packet[8:12], action)
+	binary.BigEndian.PutUint32(packet[12:16], tid)
+	packet = append(packet, body...)
+
+	_, err := t.conn.Write(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	t.conn.SetReadDeadline(deadline)
+
+	// cancelling ctx wakes the read up at once rather than at the deadline
+	conn := t.conn
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	buf := make([]byte, 8192)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != tid {
+			// a late answer to an earlier attempt
+			continue
+		}
+
+		respAction := binary.BigEndian.Uint32(buf[0:4])
+		if respAction == actionError {
+			return nil, &FailureError{URL: t.url, Reason: string(buf[8:n])}
+		}
+		if respAction != action {
+			return nil, fmt.Errorf("tracker %s answered action %d with action %d", t.url, action, respAction)
+		}
+
+		return append([]byte(nil), buf[8:n]...), nil
+	}
+}
+
+// Close releases the socket used to talk to the tracker.
+func (t *UDPTracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *UDPTracker) isIPv6() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		return false
+	}
+	addr, ok := t.conn.RemoteAddr().(*net.UDPAddr)
+	return ok && addr.IP.To4() == nil
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
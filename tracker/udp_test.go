@@ -0,0 +1,375 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker is an in-process stand-in for a BEP 15 tracker on a
+// loopback socket. It records what it receives and can drop packets to make
+// the client retransmit.
+type fakeUDPTracker struct {
+	conn net.PacketConn
+
+	mu        sync.Mutex
+	drop      int // packets to ignore before answering
+	received  int
+	connects  int
+	nextID    uint64
+	validIDs  map[uint64]bool
+	announces [][]byte
+	failure   string
+}
+
+func newFakeUDPTracker(t *testing.T) *fakeUDPTracker {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeUDPTracker{conn: conn, nextID: 0x1000, validIDs: make(map[uint64]bool)}
+	go f.serve()
+	t.Cleanup(func() { conn.Close() })
+	return f
+}
+
+func (f *fakeUDPTracker) url() string {
+	return "udp://" + f.conn.LocalAddr().String()
+}
+
+func (f *fakeUDPTracker) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := f.handle(buf[:n]); resp != nil {
+			f.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (f *fakeUDPTracker) handle(packet []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.received++
+	if f.drop > 0 {
+		f.drop--
+		return nil
+	}
+	if len(packet) < 16 {
+		return nil
+	}
+
+	connectionID := binary.BigEndian.Uint64(packet[0:8])
+	action := binary.BigEndian.Uint32(packet[8:12])
+	tid := packet[12:16]
+
+	resp := binary.BigEndian.AppendUint32(nil, action)
+	resp = append(resp, tid...)
+
+	if action == actionConnect {
+		if connectionID != udpProtocolID {
+			return nil
+		}
+		f.connects++
+		f.nextID++
+		f.validIDs[f.nextID] = true
+		return binary.BigEndian.AppendUint64(resp, f.nextID)
+	}
+
+	if !f.validIDs[connectionID] || f.failure != "" {
+		reason := f.failure
+		if reason == "" {
+			reason = "invalid connection id"
+		}
+		resp = binary.BigEndian.AppendUint32(nil, actionError)
+		resp = append(resp, tid...)
+		return append(resp, reason...)
+	}
+
+	switch action {
+	case actionAnnounce:
+		f.announces = append(f.announces, append([]byte(nil), packet[16:]...))
+		resp = binary.BigEndian.AppendUint32(resp, 1800) // interval
+		resp = binary.BigEndian.AppendUint32(resp, 3)    // leechers
+		resp = binary.BigEndian.AppendUint32(resp, 5)    // seeders
+		return append(resp, 10, 0, 0, 1, 0x1a, 0xe1)     // 10.0.0.1:6881
+	case actionScrape:
+		hashes := (len(packet) - 16) / 20
+		for i := 0; i < hashes; i++ {
+			resp = binary.BigEndian.AppendUint32(resp, uint32(10+i)) // seeders
+			resp = binary.BigEndian.AppendUint32(resp, uint32(20+i)) // completed
+			resp = binary.BigEndian.AppendUint32(resp, uint32(30+i)) // leechers
+		}
+		return resp
+	}
+	return nil
+}
+
+// expire makes the tracker forget every connection id it handed out.
+func (f *fakeUDPTracker) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.validIDs = make(map[uint64]bool)
+}
+
+func (f *fakeUDPTracker) stats() (received, connects int, announces [][]byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.received, f.connects, f.announces
+}
+
+func newTestUDPTracker(t *testing.T, f *fakeUDPTracker) *UDPTracker {
+	t.Helper()
+
+	tr, err := NewUDPTracker(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.RetransmitBase = 50 * time.Millisecond
+	tr.MaxRetries = 3
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func testAnnounceRequest() *AnnounceRequest {
+	return &AnnounceRequest{
+		InfoHash: [20]byte{1, 2, 3},
+		PeerID:   [20]byte{4, 5, 6},
+		Port:     6881,
+		Left:     1000,
+		Event:    EventStarted,
+		Key:      0xdeadbeef,
+		IPv4:     net.IPv4(192, 0, 2, 7),
+	}
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	f := newFakeUDPTracker(t)
+	tr := newTestUDPTracker(t, f)
+
+	resp, err := tr.Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Interval != 30*time.Minute || resp.Leechers != 3 || resp.Seeders != 5 {
+		t.Errorf("got interval=%s leechers=%d seeders=%d, want 30m0s 3 5", resp.Interval, resp.Leechers, resp.Seeders)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0].String() != "10.0.0.1:6881" {
+		t.Errorf("got peers %v, want [10.0.0.1:6881]", resp.Peers)
+	}
+
+	_, connects, announces := f.stats()
+	if connects != 1 || len(announces) != 1 {
+		t.Fatalf("got %d connects and %d announces, want 1 and 1", connects, len(announces))
+	}
+
+	body := announces[0]
+	if len(body) != 82 {
+		t.Fatalf("announce body is %d bytes, want 82", len(body))
+	}
+	if [20]byte(body[0:20]) != [20]byte{1, 2, 3} || [20]byte(body[20:40]) != [20]byte{4, 5, 6} {
+		t.Error("announce has the wrong info-hash or peer id")
+	}
+	checks := []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{"left", binary.BigEndian.Uint64(body[48:56]), 1000},
+		{"event", uint64(binary.BigEndian.Uint32(body[64:68])), 2},
+		{"ip", uint64(binary.BigEndian.Uint32(body[68:72])), 0xc0000207},
+		{"key", uint64(binary.BigEndian.Uint32(body[72:76])), 0xdeadbeef},
+		{"num_want", uint64(binary.BigEndian.Uint32(body[76:80])), 0xffffffff},
+		{"port", uint64(binary.BigEndian.Uint16(body[80:82])), 6881},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("announce %s = %#x, want %#x", check.name, check.got, check.want)
+		}
+	}
+
+	// the connection id is reused while it is valid
+	_, err = tr.Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, connects, _ := f.stats(); connects != 1 {
+		t.Errorf("second announce connected again: %d connects", connects)
+	}
+}
+
+func TestUDPScrape(t *testing.T) {
+	f := newFakeUDPTracker(t)
+	tr := newTestUDPTracker(t, f)
+
+	hashes := [][20]byte{{1}, {2}}
+	res, err := tr.Scrape(context.Background(), hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, hash := range hashes {
+		want := ScrapeResult{Seeders: int64(10 + i), Completed: int64(20 + i), Leechers: int64(30 + i)}
+		if res[hash] != want {
+			t.Errorf("scrape of %x = %+v, want %+v", hash[0], res[hash], want)
+		}
+	}
+
+	_, err = tr.Scrape(context.Background(), make([][20]byte, maxScrapeHashes+1))
+	if err == nil {
+		t.Error("scrape of too many hashes succeeded")
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	f := newFakeUDPTracker(t)
+	tr := newTestUDPTracker(t, f)
+
+	// lose the first connect, which the client sends again
+	f.mu.Lock()
+	f.drop = 1
+	f.mu.Unlock()
+
+	_, err := tr.Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, _, announces := f.stats()
+	if received != 3 || len(announces) != 1 {
+		t.Errorf("got %d packets and %d announces, want 3 and 1", received, len(announces))
+	}
+}
+
+func TestUDPGivesUp(t *testing.T) {
+	f := newFakeUDPTracker(t)
+	tr := newTestUDPTracker(t, f)
+	tr.RetransmitBase = 10 * time.Millisecond
+	tr.MaxRetries = 2
+
+	f.mu.Lock()
+	f.drop = 1000
+	f.mu.Unlock()
+
+	_, err := tr.Announce(context.Background(), testAnnounceRequest())
+	if err == nil {
+		t.Fatal("announce to a silent tracker succeeded")
+	}
+	if received, _, _ := f.stats(); received != 3 {
+		t.Errorf("sent %d packets, want 3", received)
+	}
+}
+
+func TestUDPConnectionIDExpiry(t *testing.T) {
+	f := newFakeUDPTracker(t)
+	tr := newTestUDPTracker(t, f)
+
+	_, err := tr.Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a connection id older than a minute is not used again
+	tr.mu.Lock()
+	tr.connectedAt = time.Now().Add(-2 * connectionIDLifetime)
+	tr.mu.Unlock()
+	f.expire()
+
+	_, err = tr.Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, connects, announces := f.stats(); connects != 2 || len(announces) != 2 {
+		t.Errorf("got %d connects and %d announces, want 2 and 2", connects, len(announces))
+	}
+}
+
+func TestUDPFailure(t *testing.T) {
+	f := newFakeUDPTracker(t)
+	tr := newTestUDPTracker(t, f)
+
+	f.mu.Lock()
+	f.failure = "torrent not registered"
+	f.mu.Unlock()
+
+	_, err := tr.Announce(context.Background(), testAnnounceRequest())
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "torrent not registered" {
+		t.Errorf("got error %v, want the tracker's failure reason", err)
+	}
+}
+
+func TestUDPCancel(t *testing.T) {
+	f := newFakeUDPTracker(t)
+	tr := newTestUDPTracker(t, f)
+	tr.RetransmitBase = 10 * time.Second
+
+	f.mu.Lock()
+	f.drop = 1000
+	f.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := tr.Announce(ctx, testAnnounceRequest())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("announce returned %s after cancel", elapsed)
+	}
+}
+
+func TestTiersClose(t *testing.T) {
+	first, second := newFakeUDPTracker(t), newFakeUDPTracker(t)
+
+	tiers, err := NewTiers([][]string{{first.url()}, {second.url(), "http://127.0.0.1:1/announce"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiers.AllTiers = true
+	tiers.TrackerTimeout = time.Second
+
+	_, err = tiers.Announce(context.Background(), testAnnounceRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var udpTrackers []*UDPTracker
+	for _, tier := range tiers.tiers {
+		for _, entry := range tier {
+			if tr, ok := entry.tracker.(*UDPTracker); ok {
+				udpTrackers = append(udpTrackers, tr)
+			}
+		}
+	}
+	if len(udpTrackers) != 2 {
+		t.Fatalf("got %d UDP trackers, want 2", len(udpTrackers))
+	}
+	for _, tr := range udpTrackers {
+		if tr.conn == nil {
+			t.Fatalf("%s has no socket after announcing", tr.URL())
+		}
+	}
+
+	err = tiers.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range udpTrackers {
+		if tr.conn != nil {
+			t.Errorf("%s still has its socket after Close", tr.URL())
+		}
+	}
+}
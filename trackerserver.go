@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"bitTorrentClient/torrentFile"
+	"bitTorrentClient/tracker/server"
+)
+
+// sweepInterval is how often the tracker drops peers that stopped announcing.
+const sweepInterval = time.Minute
+
+func runTracker(args []string) error {
+	flags := flag.NewFlagSet("tracker", flag.ExitOnError)
+	httpAddr := flags.String("http", ":6969", "address for HTTP announces and scrapes")
+	udpAddr := flags.String("udp", "", "address for UDP announces and scrapes (default off)")
+	interval := flags.Duration("interval", server.DefaultInterval, "announce interval given to clients")
+	minInterval := flags.Duration("min-interval", server.DefaultMinInterval, "minimum announce interval")
+	peerTTL := flags.Duration("peer-ttl", 2*server.DefaultInterval, "drop peers that did not announce for this long")
+	var allow, trust stringList
+	flags.Var(&allow, "allow", "only track this torrent, given as a hex info-hash or a .torrent path (repeatable)")
+	flags.Var(&trust, "trust", "let clients in this CIDR network announce another address than they connect from (repeatable)")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: tracker [flags]")
+	}
+	if *httpAddr == "" && *udpAddr == "" {
+		return fmt.Errorf("-http and -udp cannot both be off")
+	}
+
+	srv := server.New()
+	srv.Interval = *interval
+	srv.MinInterval = *minInterval
+	srv.PeerTTL = *peerTTL
+
+	for _, item := range trust {
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return fmt.Errorf("-trust %s: %v", item, err)
+		}
+		srv.TrustedNetworks = append(srv.TrustedNetworks, network)
+	}
+
+	for _, item := range allow {
+		hash, err := parseAllowed(item)
+		if err != nil {
+			return err
+		}
+		srv.Allow(hash)
+	}
+
+	go func() {
+		for range time.Tick(sweepInterval) {
+			srv.Sweep()
+		}
+	}()
+
+	errs := make(chan error, 2)
+
+	if *udpAddr != "" {
+		conn, err := net.ListenPacket("udp", *udpAddr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		fmt.Printf("tracker: serving udp on %s\n", conn.LocalAddr())
+		go func() {
+			errs <- srv.ServeUDP(conn)
+		}()
+	}
+
+	if *httpAddr != "" {
+		fmt.Printf("tracker: serving http on %s\n", *httpAddr)
+		go func() {
+			errs <- http.ListenAndServe(*httpAddr, srv.Handler())
+		}()
+	}
+
+	return <-errs
+}
+
+// parseAllowed reads an -allow value, either a hex info-hash or a .torrent.
+func parseAllowed(value string) ([20]byte, error) {
+	var hash [20]byte
+
+	decoded, err := hex.DecodeString(value)
+	if err == nil && len(decoded) == 20 {
+		copy(hash[:], decoded)
+		return hash, nil
+	}
+
+	tf, err := torrentFile.Open(value)
+	if err != nil {
+		return hash, fmt.Errorf("-allow %s: not an info-hash or torrent: %v", value, err)
+	}
+
+	infoHash, err := tf.GetInfoHash()
+	if err != nil {
+		return hash, err
+	}
+	copy(hash[:], infoHash)
+	return hash, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"bitTorrentClient/storage"
+	"bitTorrentClient/torrentFile"
+)
+
+// maxCorruptListed bounds how many corrupted pieces are listed by number.
+const maxCorruptListed = 20
+
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory holding the torrent's data")
+	workers := flags.Int("workers", 0, "pieces hashed in parallel (default one per CPU)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: verify [--dir .] [--workers n] <path-to-file>")
+	}
+
+	tf, err := torrentFile.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	pieceHashes := tf.GetPieceHashes()
+	entries := tf.FileEntries()
+	store, err := storage.OpenFiles(*dir, tf.Info.PieceLength, entries)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	res := storage.Check(store, pieceHashes, tf.Info.PieceLength, int64(tf.CalculateSize()), *workers)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSIZE\tCOMPLETE")
+	for i, verified := range res.FileCompletion(entries, tf.Info.PieceLength) {
+		percent := 100.0
+		if entries[i].Length > 0 {
+			percent = float64(verified) * 100 / float64(entries[i].Length)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\n", filepath.Join(entries[i].Path...), humanSize(entries[i].Length), percent)
+	}
+	w.Flush()
+
+	missing := len(pieceHashes) - res.Have.Count() - len(res.Corrupt)
+	fmt.Printf("\npieces: %d/%d verified, %d missing, %d corrupt\n", res.Have.Count(), len(pieceHashes), missing, len(res.Corrupt))
+
+	if len(res.Corrupt) > 0 {
+		listed := res.Corrupt[:min(len(res.Corrupt), maxCorruptListed)]
+		fmt.Printf("corrupt pieces: %v", listed)
+		if len(listed) < len(res.Corrupt) {
+			fmt.Printf(" and %d more", len(res.Corrupt)-len(listed))
+		}
+		fmt.Println()
+	}
+
+	if res.Have.Count() < len(pieceHashes) {
+		return fmt.Errorf("%s is incomplete", tf.Info.Name)
+	}
+	return nil
+}